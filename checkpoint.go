@@ -0,0 +1,158 @@
+package logtailer
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// headSize is the number of bytes at the start of a log file that are hashed to
+// recognize the file after it has been rotated.
+const headSize = 1024
+
+// fileID identifies a log file independently of the path it is found at.
+type fileID struct {
+	Inode   uint64 `json:"inode"`
+	HeadLen int    `json:"head_len"`
+	Head    string `json:"head"`
+}
+
+// checkpoint records how far into a log file the tailer has consumed. It is
+// stored as JSON at stateFilePath().
+type checkpoint struct {
+	fileID
+	Offset int64 `json:"offset"`
+	// Line is the number of lines before Offset.
+	Line int64 `json:"line"`
+	// Time is when the checkpoint was saved.
+	Time time.Time `json:"time"`
+}
+
+// identify computes the fileID of a file from its stat and head, the first
+// headSize bytes of its content.
+func identify(fi os.FileInfo, head []byte) fileID {
+	sum := sha1.Sum(head)
+	return fileID{
+		Inode:   inode(fi),
+		HeadLen: len(head),
+		Head:    hex.EncodeToString(sum[:]),
+	}
+}
+
+// readHead returns the first headSize bytes of f, or all of it if shorter.
+func readHead(f *os.File) ([]byte, error) {
+	buf := make([]byte, headSize)
+	n, err := f.ReadAt(buf, 0)
+	if err == io.EOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+// inode returns the inode number of a file, or 0 if it is unavailable.
+func inode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// matchesHead reports whether head starts with the content identified by id.
+// The file may have grown since id was taken, so only the first HeadLen bytes
+// are compared.
+func (id fileID) matchesHead(head []byte) bool {
+	if len(head) < id.HeadLen {
+		return false
+	}
+	sum := sha1.Sum(head[:id.HeadLen])
+	return hex.EncodeToString(sum[:]) == id.Head
+}
+
+// sameContent reports whether seg holds the content the checkpoint was taken
+// from, wherever it has been moved or compressed to since. Checkpoints without
+// a head, such as those left by logtail2, can only be matched by inode.
+func (cp *checkpoint) sameContent(seg *segment) bool {
+	if cp.HeadLen == 0 {
+		return cp.Inode == seg.id.Inode
+	}
+	return cp.matchesHead(seg.head)
+}
+
+// sameFile reports whether seg is the very file the checkpoint was taken from.
+func (cp *checkpoint) sameFile(seg *segment) bool {
+	return cp.Inode == seg.id.Inode && (cp.HeadLen == 0 || cp.matchesHead(seg.head))
+}
+
+// loadCheckpoint reads the checkpoint stored at path. A missing state file is
+// not an error and yields a nil checkpoint.
+//
+// State files written by logtail2 (inode and offset on separate lines) are
+// accepted so that existing installations resume where they left off.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(buf, cp); err == nil {
+		return cp, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(buf)))
+	var fields []string
+	for scanner.Scan() {
+		fields = append(fields, strings.TrimSpace(scanner.Text()))
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("unrecognized state file %s", path)
+	}
+	if cp.Inode, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return nil, fmt.Errorf("bad inode in state file %s: %v", path, err)
+	}
+	if cp.Offset, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return nil, fmt.Errorf("bad offset in state file %s: %v", path, err)
+	}
+	return cp, nil
+}
+
+// save atomically replaces the state file at path with the checkpoint.
+func (cp *checkpoint) save(path string) error {
+	cp.Time = time.Now()
+	buf, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
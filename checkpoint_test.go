@@ -0,0 +1,259 @@
+package logtailer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/facebookgo/ensure"
+	"github.com/klauspost/compress/zstd"
+)
+
+// collectProfile records every line it is handed. Lines are collected in
+// ProcessRecord since Run has returned by the time the workers are done.
+type collectProfile struct {
+	sync.Mutex
+	lines []string
+}
+
+func (p *collectProfile) Name() string { return "collect" }
+func (p *collectProfile) Init() error  { return nil }
+
+func (p *collectProfile) ProcessRecord(record string) (interface{}, error) {
+	p.Lock()
+	p.lines = append(p.lines, record)
+	p.Unlock()
+	return record, nil
+}
+
+func (p *collectProfile) HandleOutput(records <-chan interface{}, dryRun bool) <-chan error {
+	errChan := make(chan error)
+	go func() {
+		defer close(errChan)
+		for range records {
+		}
+	}()
+	return errChan
+}
+
+// tailOnce runs a fresh tailer over logFile and returns the lines it read.
+func tailOnce(t *testing.T, logFile, stateDir string) []string {
+	p := &collectProfile{}
+	lt := NewLogtailerFiles(p, []string{logFile}, stateDir, log.New(ioutil.Discard, "", 0))
+	_, err := lt.Run(1)
+	ensure.Nil(t, err)
+	return p.lines
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	ensure.Nil(t, err)
+	defer f.Close()
+	for _, line := range lines {
+		_, err := fmt.Fprintln(f, line)
+		ensure.Nil(t, err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "logtailer")
+	ensure.Nil(t, err)
+	return dir
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+
+	appendLines(t, logFile, "1", "2")
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"1", "2"})
+	appendLines(t, logFile, "3")
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"3"})
+	ensure.DeepEqual(t, len(tailOnce(t, logFile, dir)), 0)
+}
+
+func TestLogFileAlongWithLogFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	otherFile := filepath.Join(dir, "other.log")
+	appendLines(t, logFile, "1")
+	appendLines(t, otherFile, "2")
+
+	p := &collectProfile{}
+	lt := NewLogtailer(p, logFile, dir, log.New(ioutil.Discard, "", 0))
+	lt.LogFiles = []string{otherFile}
+	_, err := lt.Run(1)
+	ensure.Nil(t, err)
+	sort.Strings(p.lines)
+	ensure.DeepEqual(t, p.lines, []string{"1", "2"})
+
+	// the checkpoint is the same whichever way the file is given
+	appendLines(t, logFile, "3")
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"3"})
+}
+
+func TestCheckpointTruncation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+
+	appendLines(t, logFile, "first run line")
+	tailOnce(t, logFile, dir)
+	ensure.Nil(t, os.Truncate(logFile, 0))
+	appendLines(t, logFile, "a")
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"a"})
+}
+
+func TestCheckpointRotation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+
+	appendLines(t, logFile, "1")
+	tailOnce(t, logFile, dir)
+	appendLines(t, logFile, "2")
+	ensure.Nil(t, os.Rename(logFile, logFile+".1"))
+	appendLines(t, logFile, "3")
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"2", "3"})
+	ensure.DeepEqual(t, len(tailOnce(t, logFile, dir)), 0)
+}
+
+// compress replaces the file at path with a compressed copy named dst.
+func compress(t *testing.T, path, dst string) {
+	in, err := os.Open(path)
+	ensure.Nil(t, err)
+	defer in.Close()
+	out, err := os.Create(dst)
+	ensure.Nil(t, err)
+	defer out.Close()
+
+	var w io.WriteCloser
+	if filepath.Ext(dst) == ".zst" {
+		w, err = zstd.NewWriter(out)
+		ensure.Nil(t, err)
+	} else {
+		w = gzip.NewWriter(out)
+	}
+	_, err = io.Copy(w, in)
+	ensure.Nil(t, err)
+	ensure.Nil(t, w.Close())
+	ensure.Nil(t, os.Remove(path))
+}
+
+func TestCatchUpCompressedRotations(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+
+	appendLines(t, logFile, "a1")
+	tailOnce(t, logFile, dir)
+	appendLines(t, logFile, "a2")
+
+	// three rotations while no tailer ran, older files get compressed
+	age := time.Now().Add(-time.Hour)
+	for _, next := range []string{"b", "c", "d"} {
+		os.Rename(logFile+".2", logFile+".3")
+		os.Rename(logFile+".1", logFile+".2")
+		ensure.Nil(t, os.Rename(logFile, logFile+".1"))
+		appendLines(t, logFile, next)
+	}
+	compress(t, logFile+".3", logFile+".3.zst")
+	compress(t, logFile+".2", logFile+".2.gz")
+	for i, name := range []string{".3.zst", ".2.gz", ".1"} {
+		mtime := age.Add(time.Duration(i) * time.Minute)
+		ensure.Nil(t, os.Chtimes(logFile+name, mtime, mtime))
+	}
+
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"a2", "b", "c", "d"})
+	ensure.DeepEqual(t, len(tailOnce(t, logFile, dir)), 0)
+}
+
+func TestCatchUpLogtail2Checkpoint(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+
+	// the file logtail2 last read is gone, leaving only later rotations
+	appendLines(t, logFile+".2", "a")
+	compress(t, logFile+".2", logFile+".2.gz")
+	appendLines(t, logFile+".1", "b")
+	appendLines(t, logFile, "c")
+	lt := NewLogtailerFiles(&collectProfile{}, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	ensure.Nil(t, ioutil.WriteFile(lt.stateFilePath(logFile), []byte("1\n2\n"), 0644))
+
+	// with no time in the checkpoint the rotations are not replayed
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"c"})
+}
+
+func TestLoadLogtail2Checkpoint(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	ensure.Nil(t, ioutil.WriteFile(path, []byte("1234\n56\n"), 0644))
+	cp, err := loadCheckpoint(path)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, cp.Inode, uint64(1234))
+	ensure.DeepEqual(t, cp.Offset, int64(56))
+}
+
+// recordProfile keeps the full records it is handed.
+type recordProfile struct {
+	collectProfile
+	records []profiles.Record
+}
+
+func (p *recordProfile) ProcessFullRecord(record *profiles.Record) (interface{}, error) {
+	p.Lock()
+	p.records = append(p.records, *record)
+	p.Unlock()
+	return record.Text, nil
+}
+
+func TestRecordMetadata(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "one", "two")
+	tailOnce(t, logFile, dir)
+	appendLines(t, logFile, "three")
+
+	p := &recordProfile{}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	_, err := lt.Run(1)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(p.records), 1)
+	r := p.records[0]
+	ensure.DeepEqual(t, r.Text, "three")
+	ensure.DeepEqual(t, r.Source, logFile)
+	ensure.DeepEqual(t, r.Offset, int64(8))
+	ensure.DeepEqual(t, r.Line, int64(3))
+	ensure.DeepEqual(t, r.Hostname, hostname)
+	ensure.False(t, r.ReadTime.IsZero())
+}
+
+func TestDefaultSpoolDir(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	a := NewLogtailerFiles(&collectProfile{}, []string{"/var/log/a.log"}, "/var/run/logtailer", logger)
+	b := NewLogtailerFiles(&collectProfile{}, []string{"/var/log/b.log"}, "/var/run/logtailer", logger)
+	ensure.DeepEqual(t, a.DefaultSpoolDir(), "/var/run/logtailer/logtailer-collect-var_log_a.log.spool")
+	ensure.NotDeepEqual(t, a.DefaultSpoolDir(), b.DefaultSpoolDir())
+
+	var many []string
+	for i := 0; i < 50; i++ {
+		many = append(many, fmt.Sprintf("/var/log/app%d.log", i))
+	}
+	c := NewLogtailerFiles(&collectProfile{}, many, "/var/run/logtailer", logger)
+	ensure.True(t, len(filepath.Base(c.DefaultSpoolDir())) < 255)
+}
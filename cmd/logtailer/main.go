@@ -1,7 +1,8 @@
 // Command logtailer is designed to process log files for consumption.
 //
-// When provided a file for the `log_file` argument it invokes logtail2 to
-// peform log file checkpointing including rotation detection.
+// When provided a file for the `log_file` argument it records how far it has
+// read in a state file under `state_dir`, and resumes from there on the next
+// run, detecting rotation and truncation of the log file.
 //
 // The first argument must be the profile identifier.
 //
@@ -11,92 +12,215 @@
 //
 //	* * * * * /usr/bin/logtailer nginx -log_file=/mnt/log/nginx/access.log
 //
-// See profile_dummy.go for an example of adding your own profile.
+// With `-follow` it runs continuously instead, like `tail -F`, until it
+// receives SIGTERM or SIGINT, after which it has `-shutdown_timeout` to
+// deliver what it has read:
+//
+//	/usr/bin/logtailer nginx -follow -log_file=/mnt/log/nginx/access.log
+//
+// Several log files, or glob patterns, may be given separated by commas. In
+// follow mode files matching a pattern are picked up as they are created:
+//
+//	/usr/bin/logtailer mongodb -follow -log_file='/var/log/mongodb/*.log'
+//
+// Records longer than `-max_record_size` are truncated by default, or with
+// `-oversize=skip` or `-oversize=spill` dropped, spill saving them in full to
+// a file next to the state file.
+//
+// With `-metrics_addr` metrics are served for Prometheus to scrape while it
+// runs:
+//
+//	/usr/bin/logtailer sshd -follow -log_file=/var/log/auth.log -metrics_addr=:9100
+//
+// The output of any profile can be sent elsewhere with `-sink`:
+//
+//	/usr/bin/logtailer mongodb -log_file=/var/log/mongodb/mongod.log -sink=unix:/run/collector.sock
+//
+// With `-dead_letter_file` records that fail to parse are kept in that file,
+// and can be run through the profile again once the parser is fixed:
+//
+//	/usr/bin/logtailer replay-dlq mongodb -dead_letter_file=/var/run/logtailer/mongodb.dlq
+//
+// To run many tailers in one process, list them in a YAML config file, see
+// logtailer.Config, and run them all in follow mode with:
+//
+//	/usr/bin/logtailer run -config=/etc/logtailer.yaml
+//
+// On SIGHUP the config file is read again, and tailers are started, stopped
+// or restarted to match it.
+//
+// See profiles/dummy for an example of adding your own profile. Profiles
+// register themselves with profiles.Register when their package is imported,
+// so a custom binary only needs to import its profile packages.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
-	"sort"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ParsePlatform/logtailer"
 	"github.com/ParsePlatform/logtailer/profiles"
-	"github.com/ParsePlatform/logtailer/profiles/dummy"
-	"github.com/ParsePlatform/logtailer/profiles/mongodb"
-	"github.com/ParsePlatform/logtailer/profiles/sshd"
+	"github.com/ParsePlatform/logtailer/sinks"
+
+	// profiles register themselves when imported, import yours here to build
+	// it into the binary
+	_ "github.com/ParsePlatform/logtailer/profiles/dummy"
+	_ "github.com/ParsePlatform/logtailer/profiles/haproxy"
+	_ "github.com/ParsePlatform/logtailer/profiles/mongodb"
+	_ "github.com/ParsePlatform/logtailer/profiles/mysql"
+	_ "github.com/ParsePlatform/logtailer/profiles/nginx"
+	_ "github.com/ParsePlatform/logtailer/profiles/postgresql"
+	_ "github.com/ParsePlatform/logtailer/profiles/sshd"
+	_ "github.com/ParsePlatform/logtailer/profiles/syslog"
 )
 
 var (
-	logFile    = flag.String("log_file", "", "The input log file to consume.")
-	stateDir   = flag.String("state_dir", "/var/run/logtailer", "The directory that will hold log tailing state.")
-	dryRun     = flag.Bool("dry_run", false, "If True, will only print to stdout and will not update any state.")
-	numWorkers = flag.Int("num_workers", 1, "Number of processing goroutines to run (1 for sequential).")
-	goMaxProcs = flag.Int("gomaxprocs", runtime.NumCPU(), "Sets the number of os threads that will be utilized")
+	configFile         = flag.String("config", "", "The config file listing the tailers to run with the run command.")
+	profileOptions     = flag.String("profile_options", "", "Comma separated key=value options for the profile, e.g. rocksdb_fields=true for mongodb.")
+	logFile            = flag.String("log_file", "", "The input log files to consume, comma separated. Glob patterns are expanded.")
+	stateDir           = flag.String("state_dir", "/var/run/logtailer", "The directory that will hold log tailing state.")
+	dryRun             = flag.Bool("dry_run", false, "If True, will only print to stdout and will not update any state.")
+	numWorkers         = flag.Int("num_workers", 1, "Number of processing goroutines to run (1 for sequential).")
+	ordered            = flag.Bool("ordered", false, "If True, keep the output in input order when running several workers.")
+	follow             = flag.Bool("follow", false, "If True, keep following the log file across rotations instead of exiting at EOF.")
+	checkpointInterval = flag.Duration("checkpoint_interval", 10*time.Second, "How often to save the checkpoint when following.")
+	maxRecordSize      = flag.Int("max_record_size", logtailer.DefaultMaxRecordSize, "The size in bytes of the largest record handed to the profile.")
+	oversize           = flag.String("oversize", string(logtailer.TruncateOversize), "What to do with larger records: truncate, skip or spill.")
+	sinkSpec           = flag.String("sink", "", "Where to send the output instead of the profile's default: stdout, file:PATH, unix:PATH or an http(s) URL.")
+	sinkOptions        = flag.String("sink_options", "", "Comma separated key=value options for the sink, e.g. gzip=true,batch_count=100,token_file=PATH,header.NAME=VALUE.")
+	spool              = flag.Bool("spool", false, "If True, keep output for the sink on disk under state_dir until the sink delivers it.")
+	deadLetterFile     = flag.String("dead_letter_file", "", "The file records that fail to parse are appended to, and replay-dlq replays.")
+	metricsAddr        = flag.String("metrics_addr", "", "If set, the address to serve Prometheus metrics on at /metrics, e.g. :9100.")
+	shutdownTimeout    = flag.Duration("shutdown_timeout", 30*time.Second, "How long to wait on SIGTERM or SIGINT for the records already read to be delivered before giving up.")
+	goMaxProcs         = flag.Int("gomaxprocs", runtime.NumCPU(), "Sets the number of os threads that will be utilized")
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage of %[1]s:\n\t%[1]s profile_name [arguments]\n\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "Available profiles: %s\n\nArguments:\n", profileNames())
+	fmt.Fprintf(os.Stderr, "Usage of %[1]s:\n\t%[1]s profile_name [arguments]\n\t%[1]s replay-dlq profile_name -dead_letter_file=file [arguments]\n\t%[1]s run -config=file [arguments]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Available profiles: %s\n\nArguments:\n", strings.Join(profiles.List(), ", "))
 	flag.PrintDefaults()
 }
 
-// availableProfiles defines the registered logtailer profiles.
-// To add a new profile you must add it to this map.
-// TODO(tredman): convert mysql, nginx, and haproxy tailers
-var availableProfiles = map[string]profiles.Profile{
-	"dummy":   new(dummy.DummyProfile),
-	"mongodb": new(mongodb.MongodbProfile),
-	"sshd":    new(sshd.SshdProfile),
-}
-
 func main() {
 	// TODO: pick a better logger with support for Info, Debug, etc
 	logger := log.New(os.Stderr, "DEBUG: ", log.LstdFlags|log.Lshortfile)
 	flag.Usage = usage
 
-	if len(os.Args) == 1 {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "run" {
+		flag.CommandLine.Parse(args[1:])
+		runDaemon(logger)
+		return
+	}
+	replay := len(args) > 0 && args[0] == "replay-dlq"
+	if replay {
+		args = args[1:]
+	}
+	if len(args) == 0 {
 		flag.Usage()
 		logger.Fatalln("No profile specified.")
 	}
 
-	profileName := os.Args[1]
-	p, ok := availableProfiles[profileName]
+	profileName := args[0]
+	newProfile, ok := profiles.Lookup(profileName)
 	if !ok {
 		flag.Usage()
 		logger.Fatalln(fmt.Sprintf("Invalid profile '%s' profile selected.\n", profileName))
 	}
 
-	flag.CommandLine.Parse(os.Args[2:])
+	flag.CommandLine.Parse(args[1:])
 
 	runtime.GOMAXPROCS(*goMaxProcs)
 
-	if *logFile == "" {
+	if replay && *deadLetterFile == "" {
+		flag.Usage()
+		logger.Fatalln("No dead-letter file specified (-dead_letter_file argument).")
+	}
+	if !replay && *logFile == "" {
 		flag.Usage()
 		logger.Fatalln("No log file specified (-log_file argument).")
 	}
 
-	tailer := logtailer.NewLogtailer(p, *logFile, *stateDir, logger)
+	oversizePolicy, err := logtailer.ParseOversizePolicy(*oversize)
+	if err != nil {
+		flag.Usage()
+		logger.Fatalln(err)
+	}
+	if *maxRecordSize < 1 {
+		flag.Usage()
+		logger.Fatalln("-max_record_size must be at least 1.")
+	}
+
+	options, err := sinks.ParseOptions(*profileOptions)
+	if err != nil {
+		flag.Usage()
+		logger.Fatalln(err)
+	}
+	p, err := newProfile(profiles.Options(options))
+	if err != nil {
+		logger.Fatalln("error creating profile: ", err)
+	}
+
+	tailer := logtailer.NewLogtailerFiles(p, strings.Split(*logFile, ","), *stateDir, logger)
 	tailer.DryRun = *dryRun
+	tailer.Follow = *follow
+	tailer.Ordered = *ordered
+	tailer.CheckpointInterval = *checkpointInterval
+	tailer.MaxRecordSize = *maxRecordSize
+	tailer.OversizePolicy = oversizePolicy
+	tailer.DeadLetterFile = *deadLetterFile
+	if *sinkSpec != "" {
+		options, err := sinks.ParseOptions(*sinkOptions)
+		if err == nil {
+			tailer.Sink, err = sinks.New(*sinkSpec, options)
+		}
+		if err != nil {
+			flag.Usage()
+			logger.Fatalln(err)
+		}
+	}
+	if *spool {
+		if tailer.Sink == nil {
+			flag.Usage()
+			logger.Fatalln("No sink to spool for (-sink argument).")
+		}
+		tailer.SpoolDir = tailer.DefaultSpoolDir()
+	}
+
+	if replay {
+		stats, err := tailer.ReplayDeadLetters(*numWorkers)
+		if err != nil {
+			logger.Fatalln("error in replay: ", err)
+		}
+		fmt.Fprintln(os.Stderr, stats)
+		return
+	}
 
 	if err := tailer.PrepEnvironment(); err != nil {
 		logger.Fatalln("logtailer: issue with environment: ", err)
 	}
 
-	// on first first TERM/INT, stop the tailer and the signal handler
+	// on first first TERM/INT, stop the signal handler and let the tailer
+	// finish with what it has read, a second one kills it
 	ch := make(chan os.Signal, 2)
 	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
 		<-ch
 		signal.Stop(ch)
-		tailer.Stop()
+		shutdown(logger, tailer.Shutdown)
 	}()
 
+	serveMetrics(logger, tailer.MetricsHandler())
+
 	stats, err := tailer.Run(*numWorkers)
 	if err != nil {
 		logger.Fatalln("error in run: ", err)
@@ -104,11 +228,86 @@ func main() {
 	fmt.Fprintln(os.Stderr, stats)
 }
 
-func profileNames() string {
-	names := []string{}
-	for k := range availableProfiles {
-		names = append(names, k)
+// runDaemon runs the tailers in the config file until it receives SIGTERM or
+// SIGINT, reloading the config on SIGHUP.
+func runDaemon(logger *log.Logger) {
+	runtime.GOMAXPROCS(*goMaxProcs)
+
+	if *configFile == "" {
+		flag.Usage()
+		logger.Fatalln("No config file specified (-config argument).")
 	}
-	sort.Strings(names)
-	return strings.Join(names, ", ")
+	config, err := loadConfig()
+	if err != nil {
+		logger.Fatalln(err)
+	}
+	daemon := logtailer.NewDaemon(config, logger)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			config, err := loadConfig()
+			if err == nil {
+				err = daemon.Reload(config)
+			}
+			if err != nil {
+				logger.Println("error reloading config: ", err)
+				continue
+			}
+			logger.Println("reloaded", *configFile)
+		}
+	}()
+
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-ch
+		signal.Stop(ch)
+		shutdown(logger, daemon.Shutdown)
+	}()
+
+	serveMetrics(logger, daemon.MetricsHandler())
+
+	if err := daemon.Run(); err != nil {
+		logger.Fatalln("error in run: ", err)
+	}
+	for name, stats := range daemon.Stats() {
+		fmt.Fprintln(os.Stderr, name, stats)
+	}
+}
+
+// loadConfig reads the config file, with -state_dir as the default state
+// directory.
+func loadConfig() (*logtailer.Config, error) {
+	config, err := logtailer.LoadConfig(*configFile)
+	if err != nil {
+		return nil, err
+	}
+	if config.StateDir == "" {
+		config.StateDir = *stateDir
+	}
+	return config, nil
+}
+
+// shutdown stops the tailers with shutdownFunc, exiting if they are not done
+// within -shutdown_timeout.
+func shutdown(logger *log.Logger, shutdownFunc func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := shutdownFunc(ctx); err != nil {
+		logger.Fatalln("error shutting down: ", err)
+	}
+}
+
+// serveMetrics serves handler at /metrics on -metrics_addr, if set.
+func serveMetrics(logger *log.Logger, handler http.Handler) {
+	if *metricsAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	go func() {
+		logger.Println("error serving metrics: ", http.ListenAndServe(*metricsAddr, mux))
+	}()
 }
@@ -0,0 +1,168 @@
+package logtailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/ParsePlatform/logtailer/sinks"
+	"gopkg.in/yaml.v2"
+)
+
+// Config describes the tailers a Daemon runs. It is usually read from a YAML
+// file by LoadConfig:
+//
+//	state_dir: /var/run/logtailer
+//	tailers:
+//	  - profile: mongodb
+//	    log_files: [/var/log/mongodb/*.log]
+//	    options: {rocksdb_fields: true}
+//	    sink: unix:/run/collector.sock
+//	  - name: sshd-bastion
+//	    profile: sshd
+//	    log_files: [/var/log/auth.log]
+//	    options: {authorized_keys_path: /etc/ssh/bastion_keys}
+//	    sink: https://collector.example.com/input
+//	    sink_options: {gzip: true, token_file: /etc/logtailer/token}
+//	    spool: true
+//	    num_workers: 4
+type Config struct {
+	StateDir string         `yaml:"state_dir"`
+	Tailers  []TailerConfig `yaml:"tailers"`
+}
+
+// TailerConfig describes a single tailer. The fields mirror the command line
+// flags of the same name. Name defaults to the profile, and must be unique.
+type TailerConfig struct {
+	Name               string            `yaml:"name"`
+	Profile            string            `yaml:"profile"`
+	Options            map[string]string `yaml:"options"`
+	LogFiles           []string          `yaml:"log_files"`
+	NumWorkers         int               `yaml:"num_workers"`
+	Ordered            bool              `yaml:"ordered"`
+	CheckpointInterval time.Duration     `yaml:"checkpoint_interval"`
+	MaxRecordSize      int               `yaml:"max_record_size"`
+	Oversize           string            `yaml:"oversize"`
+	Sink               string            `yaml:"sink"`
+	SinkOptions        map[string]string `yaml:"sink_options"`
+	Spool              bool              `yaml:"spool"`
+	DeadLetterFile     string            `yaml:"dead_letter_file"`
+}
+
+// LoadConfig reads and checks the config file at path.
+func LoadConfig(path string) (*Config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.Unmarshal(buf, &config); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if err := config.check(); err != nil {
+		return nil, fmt.Errorf("error in %s: %v", path, err)
+	}
+	return &config, nil
+}
+
+// check fills in defaults and checks that the tailers can be created.
+func (c *Config) check() error {
+	if len(c.Tailers) == 0 {
+		return fmt.Errorf("no tailers")
+	}
+	names := make(map[string]bool)
+	// checkpoints are kept by profile and log file, so two tailers with the
+	// same profile must not read the same file
+	tailed := make(map[[2]string]string)
+	for i := range c.Tailers {
+		t := &c.Tailers[i]
+		if t.Name == "" {
+			t.Name = t.Profile
+		}
+		if t.NumWorkers == 0 {
+			t.NumWorkers = 1
+		}
+		if t.CheckpointInterval == 0 {
+			t.CheckpointInterval = 10 * time.Second
+		}
+		if t.MaxRecordSize == 0 {
+			t.MaxRecordSize = DefaultMaxRecordSize
+		}
+		if t.Oversize == "" {
+			t.Oversize = string(TruncateOversize)
+		}
+
+		if _, ok := profiles.Lookup(t.Profile); !ok {
+			return fmt.Errorf("tailer %d: unknown profile %q", i, t.Profile)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("tailer %d: duplicate name %q", i, t.Name)
+		}
+		names[t.Name] = true
+		if len(t.LogFiles) == 0 {
+			return fmt.Errorf("tailer %s: no log files", t.Name)
+		}
+		for _, path := range t.LogFiles {
+			if path == "-" {
+				return fmt.Errorf("tailer %s: cannot read stdin", t.Name)
+			}
+			key := [2]string{t.Profile, filepath.Clean(path)}
+			if other, ok := tailed[key]; ok {
+				return fmt.Errorf("tailer %s: %s is already tailed by %s", t.Name, path, other)
+			}
+			tailed[key] = t.Name
+		}
+		if t.NumWorkers < 1 {
+			return fmt.Errorf("tailer %s: num_workers must be at least 1", t.Name)
+		}
+		if t.CheckpointInterval < 0 {
+			return fmt.Errorf("tailer %s: checkpoint_interval must be positive", t.Name)
+		}
+		if t.MaxRecordSize < 1 {
+			return fmt.Errorf("tailer %s: max_record_size must be at least 1", t.Name)
+		}
+		if _, err := ParseOversizePolicy(t.Oversize); err != nil {
+			return fmt.Errorf("tailer %s: %v", t.Name, err)
+		}
+		if t.Sink != "" {
+			// a bad sink would only show once the tailer starts
+			if _, err := sinks.New(t.Sink, t.SinkOptions); err != nil {
+				return fmt.Errorf("tailer %s: %v", t.Name, err)
+			}
+		} else if len(t.SinkOptions) > 0 {
+			return fmt.Errorf("tailer %s: sink_options without a sink", t.Name)
+		}
+		if t.Spool && t.Sink == "" {
+			return fmt.Errorf("tailer %s: no sink to spool for", t.Name)
+		}
+	}
+	return nil
+}
+
+// newLogtailer creates a follow mode tailer, with a new instance of its
+// profile, as described by c.
+func (c *TailerConfig) newLogtailer(stateDir string, logger *log.Logger) (*Logtailer, error) {
+	p, err := profiles.New(c.Profile, profiles.Options(c.Options))
+	if err != nil {
+		return nil, fmt.Errorf("error creating profile: %v", err)
+	}
+	lt := NewLogtailerFiles(p, c.LogFiles, stateDir, logger)
+	lt.Follow = true
+	lt.Ordered = c.Ordered
+	lt.CheckpointInterval = c.CheckpointInterval
+	lt.MaxRecordSize = c.MaxRecordSize
+	lt.OversizePolicy = OversizePolicy(c.Oversize)
+	lt.DeadLetterFile = c.DeadLetterFile
+	if c.Sink != "" {
+		if lt.Sink, err = sinks.New(c.Sink, c.SinkOptions); err != nil {
+			return nil, err
+		}
+	}
+	if c.Spool {
+		lt.SpoolDir = filepath.Join(stateDir, fmt.Sprintf("logtailer-%s.spool", c.Name))
+	}
+	return lt, nil
+}
@@ -0,0 +1,93 @@
+package logtailer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/facebookgo/ensure"
+)
+
+func init() {
+	profiles.Register("collect", func(options profiles.Options) (profiles.Profile, error) {
+		return &collectProfile{}, nil
+	})
+}
+
+// writeConfig writes a config file to dir and loads it.
+func writeConfig(t *testing.T, dir, config string) (*Config, error) {
+	path := filepath.Join(dir, "logtailer.yaml")
+	ensure.Nil(t, ioutil.WriteFile(path, []byte(config), 0644))
+	return LoadConfig(path)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	config, err := writeConfig(t, dir, `
+state_dir: /var/run/logtailer
+tailers:
+  - profile: collect
+    log_files: [/var/log/app.log]
+  - name: other
+    profile: collect
+    options: {verbose: true, level: 3}
+    log_files: [/var/log/other/*.log, /var/log/other.log]
+    num_workers: 4
+    checkpoint_interval: 1m
+    sink: https://collector.example.com/input
+    sink_options: {gzip: true}
+    spool: true
+`)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, config.StateDir, "/var/run/logtailer")
+	ensure.DeepEqual(t, len(config.Tailers), 2)
+
+	first := config.Tailers[0]
+	ensure.DeepEqual(t, first.Name, "collect")
+	ensure.DeepEqual(t, first.NumWorkers, 1)
+	ensure.DeepEqual(t, first.CheckpointInterval, 10*time.Second)
+	ensure.DeepEqual(t, first.MaxRecordSize, DefaultMaxRecordSize)
+	ensure.DeepEqual(t, first.Oversize, string(TruncateOversize))
+
+	other := config.Tailers[1]
+	ensure.DeepEqual(t, other.Name, "other")
+	ensure.DeepEqual(t, other.Options, map[string]string{"verbose": "true", "level": "3"})
+	ensure.DeepEqual(t, other.LogFiles, []string{"/var/log/other/*.log", "/var/log/other.log"})
+	ensure.DeepEqual(t, other.NumWorkers, 4)
+	ensure.DeepEqual(t, other.CheckpointInterval, time.Minute)
+	ensure.DeepEqual(t, other.Sink, "https://collector.example.com/input")
+	ensure.DeepEqual(t, other.SinkOptions, map[string]string{"gzip": "true"})
+	ensure.True(t, other.Spool)
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct{ config, err string }{
+		{"tailers: []", "no tailers"},
+		{"tailers: [{profile: nope, log_files: [a]}]", `unknown profile "nope"`},
+		{"tailers: [{profile: collect}]", "no log files"},
+		{"tailers: [{profile: collect, log_files: [-]}]", "cannot read stdin"},
+		{"tailers: [{profile: collect, log_files: [a]}, {profile: collect, log_files: [b]}]", `duplicate name "collect"`},
+		{"tailers: [{profile: collect, log_files: [a]}, {name: b, profile: collect, log_files: [./a]}]", "a is already tailed by collect"},
+		{"tailers: [{profile: collect, log_files: [a], oversize: shrink}]", "unknown oversize policy"},
+		{"tailers: [{profile: collect, log_files: [a], spool: true}]", "no sink to spool for"},
+		{"tailers: [{profile: collect, log_files: [a], num_workers: -1}]", "num_workers must be at least 1"},
+		{"tailers: [{profile: collect, log_files: [a], max_record_size: -1}]", "max_record_size must be at least 1"},
+		{"tailers: [{profile: collect, log_files: [a], checkpoint_interval: -1s}]", "checkpoint_interval must be positive"},
+		{"tailers: [{profile: collect, log_files: [a], sink: ftp://host}]", `unknown sink "ftp://host"`},
+		{"tailers: [{profile: collect, log_files: [a], sink: stdout, sink_options: {gzip: true}}]", `sink "stdout" takes no options`},
+		{"tailers: [{profile: collect, log_files: [a], sink_options: {gzip: true}}]", "sink_options without a sink"},
+		{"tailers: {", "error parsing"},
+	} {
+		_, err := writeConfig(t, dir, c.config)
+		ensure.Err(t, err, regexp.MustCompile(regexp.QuoteMeta(c.err)))
+	}
+}
@@ -0,0 +1,333 @@
+package logtailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+)
+
+// errDaemonStopped is returned by Reload once the daemon has been stopped.
+var errDaemonStopped = errors.New("daemon stopped")
+
+// Daemon runs the tailers described by a Config side by side in one process,
+// in follow mode, restarting any that fail, until Stop is called.
+type Daemon struct {
+	Config *Config
+	Logger *log.Logger
+	// RestartDelay is how long to wait before restarting a tailer that failed.
+	RestartDelay time.Duration
+
+	shutdown chan struct{}
+	stopOnce sync.Once
+	// started is closed once Run has started the tailers, or failed to.
+	started chan struct{}
+	// running counts the supervising goroutines.
+	running sync.WaitGroup
+	// reloadMu keeps reloads from overlapping.
+	reloadMu sync.Mutex
+	mu       sync.Mutex
+	tailers  map[string]*daemonTailer
+}
+
+// daemonTailer is the state of one of the tailers of a Daemon.
+type daemonTailer struct {
+	config   TailerConfig
+	stateDir string
+	logger   *log.Logger
+	// stop is closed to stop the tailer for good, and done once it has
+	// stopped.
+	stop chan struct{}
+	done chan struct{}
+	// current is the running tailer, if any, and stats its stats. total holds
+	// the stats of the runs before it.
+	current *Logtailer
+	stats   *Stats
+	total   Stats
+}
+
+// NewDaemon prepares a Daemon running the tailers in config.
+func NewDaemon(config *Config, logger *log.Logger) *Daemon {
+	return &Daemon{
+		Config:       config,
+		Logger:       logger,
+		RestartDelay: 10 * time.Second,
+		shutdown:     make(chan struct{}),
+		started:      make(chan struct{}),
+		tailers:      make(map[string]*daemonTailer),
+	}
+}
+
+// Run starts the tailers and returns once Stop has been called and they have
+// all stopped. It fails without starting any if one of them cannot be created.
+func (d *Daemon) Run() error {
+	d.reloadMu.Lock()
+	tailers, first, err := d.prepare(d.Config, d.Config.Tailers)
+	if err == nil {
+		d.mu.Lock()
+		for i, t := range tailers {
+			d.start(t, first[i])
+		}
+		d.mu.Unlock()
+	}
+	d.reloadMu.Unlock()
+	if err != nil {
+		d.Stop()
+		close(d.started)
+		return err
+	}
+	close(d.started)
+
+	<-d.shutdown
+	d.running.Wait()
+	return nil
+}
+
+// Reload switches the daemon over to config. Tailers that are new are started,
+// those no longer listed are stopped, and those whose config has changed are
+// restarted with the new one, resuming from their checkpoints. The tailers
+// left running have their profile reloaded if it is a profiles.Reloader. If a
+// new tailer cannot be created nothing is changed. Reload waits for Run to
+// have started the tailers of the first config.
+func (d *Daemon) Reload(config *Config) error {
+	select {
+	case <-d.started:
+	case <-d.shutdown:
+		return errDaemonStopped
+	}
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	d.mu.Lock()
+	old := make(map[string]*daemonTailer, len(d.tailers))
+	for name, t := range d.tailers {
+		old[name] = t
+	}
+	var keep []*daemonTailer
+	var changed []TailerConfig
+	for _, c := range config.Tailers {
+		t, ok := old[c.Name]
+		if ok && config.StateDir == d.Config.StateDir && reflect.DeepEqual(t.config, c) {
+			keep = append(keep, t)
+		} else {
+			changed = append(changed, c)
+		}
+	}
+	d.mu.Unlock()
+
+	tailers, first, err := d.prepare(config, changed)
+	if err != nil {
+		return err
+	}
+
+	// stop the tailers being replaced or removed, and wait for them to save
+	// their checkpoints before their replacements read them
+	d.mu.Lock()
+	select {
+	case <-d.shutdown:
+		d.mu.Unlock()
+		return errDaemonStopped
+	default:
+	}
+	var stopped []*daemonTailer
+	for name, t := range old {
+		if !containsTailer(keep, t) {
+			t.halt()
+			stopped = append(stopped, t)
+			delete(d.tailers, name)
+		}
+	}
+	d.mu.Unlock()
+	for _, t := range stopped {
+		<-t.done
+	}
+
+	d.mu.Lock()
+	d.Config = config
+	for i, t := range tailers {
+		if prev, ok := old[t.config.Name]; ok {
+			t.total.add(&prev.total)
+		}
+		d.start(t, first[i])
+	}
+	var reload []profiles.Reloader
+	for _, t := range keep {
+		if t.current == nil {
+			continue
+		}
+		if r, ok := t.current.Profile.(profiles.Reloader); ok {
+			reload = append(reload, r)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, r := range reload {
+		if err := r.Reload(); err != nil {
+			d.Logger.Println("error reloading profile:", err)
+		}
+	}
+	return nil
+}
+
+// Stop stops the tailers. It may be called more than once.
+func (d *Daemon) Stop() {
+	d.stopOnce.Do(func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		close(d.shutdown)
+		for _, t := range d.tailers {
+			t.halt()
+		}
+	})
+}
+
+// Shutdown stops the tailers, like Stop, and waits for each to finish with the
+// records it has read, as Logtailer.Shutdown does. If ctx is done first
+// Shutdown returns its error.
+func (d *Daemon) Shutdown(ctx context.Context) error {
+	d.Stop()
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the stats of each tailer by name, over all its runs.
+func (d *Daemon) Stats() map[string]*Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	all := make(map[string]*Stats, len(d.tailers))
+	for name, t := range d.tailers {
+		stats := &Stats{}
+		stats.add(&t.total)
+		if t.stats != nil {
+			stats.add(t.stats)
+		}
+		all[name] = stats
+	}
+	return all
+}
+
+// prepare creates the state of each of the tailers in configs and the
+// Logtailer each first runs.
+func (d *Daemon) prepare(config *Config, configs []TailerConfig) ([]*daemonTailer, []*Logtailer, error) {
+	var tailers []*daemonTailer
+	var first []*Logtailer
+	for _, c := range configs {
+		t := &daemonTailer{
+			config:   c,
+			stateDir: config.StateDir,
+			logger:   d.tailerLogger(c.Name),
+			stop:     make(chan struct{}),
+			done:     make(chan struct{}),
+		}
+		lt, err := c.newLogtailer(t.stateDir, t.logger)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tailer %s: %v", c.Name, err)
+		}
+		tailers = append(tailers, t)
+		first = append(first, lt)
+	}
+	return tailers, first, nil
+}
+
+// start runs t, starting with lt, unless the daemon has been stopped. The
+// caller must hold d.mu.
+func (d *Daemon) start(t *daemonTailer, lt *Logtailer) {
+	select {
+	case <-d.shutdown:
+		return
+	default:
+	}
+	d.tailers[t.config.Name] = t
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		defer close(t.done)
+		d.supervise(t, lt)
+	}()
+}
+
+// halt stops t for good. The caller must hold d.mu.
+func (t *daemonTailer) halt() {
+	close(t.stop)
+	if t.current != nil {
+		t.current.Stop()
+	}
+}
+
+func containsTailer(tailers []*daemonTailer, t *daemonTailer) bool {
+	for _, other := range tailers {
+		if other == t {
+			return true
+		}
+	}
+	return false
+}
+
+// supervise runs lt, and a new tailer in its place whenever it stops, until
+// t is stopped.
+func (d *Daemon) supervise(t *daemonTailer, lt *Logtailer) {
+	for {
+		err := lt.PrepEnvironment()
+		if err == nil {
+			stats := &Stats{}
+			d.mu.Lock()
+			select {
+			case <-t.stop:
+				d.mu.Unlock()
+				return
+			default:
+			}
+			t.current, t.stats = lt, stats
+			d.mu.Unlock()
+
+			err = lt.run(t.config.NumWorkers, stats)
+
+			d.mu.Lock()
+			t.total.add(stats)
+			t.current, t.stats = nil, nil
+			d.mu.Unlock()
+		}
+		select {
+		case <-t.stop:
+			return
+		default:
+		}
+		if err == nil {
+			err = fmt.Errorf("tailer stopped")
+		}
+
+		// a tailer cannot be run again once it has been stopped, so start
+		// afresh, with a new instance of the profile
+		for {
+			t.logger.Printf("restarting in %v after error: %v", d.RestartDelay, err)
+			select {
+			case <-time.After(d.RestartDelay):
+			case <-t.stop:
+				return
+			}
+			if lt, err = t.config.newLogtailer(t.stateDir, t.logger); err == nil {
+				break
+			}
+		}
+	}
+}
+
+// tailerLogger returns a logger for the tailer called name, which prefixes
+// its messages with the name.
+func (d *Daemon) tailerLogger(name string) *log.Logger {
+	return log.New(d.Logger.Writer(), d.Logger.Prefix()+name+": ", d.Logger.Flags())
+}
@@ -0,0 +1,197 @@
+package logtailer
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/facebookgo/ensure"
+)
+
+// reloads counts the calls to reloadProfile.Reload.
+var reloads int32
+
+// reloadProfile is a collectProfile that can be reloaded.
+type reloadProfile struct {
+	collectProfile
+}
+
+func (p *reloadProfile) Reload() error {
+	atomic.AddInt32(&reloads, 1)
+	return nil
+}
+
+func init() {
+	profiles.Register("reload", func(options profiles.Options) (profiles.Profile, error) {
+		return &reloadProfile{}, nil
+	})
+}
+
+// waitForFile polls path until it holds want or a second has passed.
+func waitForFile(path, want string) string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		buf, _ := ioutil.ReadFile(path)
+		if string(buf) == want || time.Now().After(deadline) {
+			return string(buf)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	appendLines(t, filepath.Join(dir, "a.log"), "a1", "a2")
+
+	// b.log does not exist yet, so its tailer fails until it does
+	config, err := writeConfig(t, dir, strings.Replace(`
+state_dir: DIR
+tailers:
+  - name: a
+    profile: collect
+    log_files: [DIR/a.log]
+    sink: file:DIR/a.out
+  - name: b
+    profile: collect
+    log_files: [DIR/b.log]
+    sink: file:DIR/b.out
+`, "DIR", dir, -1))
+	ensure.Nil(t, err)
+
+	d := NewDaemon(config, log.New(ioutil.Discard, "", 0))
+	d.RestartDelay = time.Millisecond
+	done := make(chan error)
+	go func() { done <- d.Run() }()
+
+	ensure.DeepEqual(t, waitForFile(filepath.Join(dir, "a.out"), "a1\na2\n"), "a1\na2\n")
+	appendLines(t, filepath.Join(dir, "b.log"), "b1")
+	ensure.DeepEqual(t, waitForFile(filepath.Join(dir, "b.out"), "b1\n"), "b1\n")
+	appendLines(t, filepath.Join(dir, "a.log"), "a3")
+	ensure.DeepEqual(t, waitForFile(filepath.Join(dir, "a.out"), "a1\na2\na3\n"), "a1\na2\na3\n")
+
+	w := httptest.NewRecorder()
+	d.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	ensure.StringContains(t, w.Body.String(), `logtailer_records_read_total{tailer="a",profile="collect"} 3`)
+	ensure.StringContains(t, w.Body.String(), `logtailer_records_read_total{tailer="b",profile="collect"} 1`)
+
+	ensure.Nil(t, d.Shutdown(context.Background()))
+	ensure.Nil(t, <-done)
+	stats := d.Stats()
+	ensure.DeepEqual(t, stats["a"].Records, 3)
+	ensure.DeepEqual(t, stats["b"].Records, 1)
+}
+
+func TestDaemonReload(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		appendLines(t, filepath.Join(dir, name+".log"), name+"1")
+	}
+	tailer := func(name, profile, out string) string {
+		return strings.Replace(strings.Replace(strings.Replace(`
+  - name: NAME
+    profile: PROFILE
+    log_files: [DIR/NAME.log]
+    sink: file:DIR/OUT`, "NAME", name, -1), "PROFILE", profile, -1), "OUT", out, -1)
+	}
+	load := func(tailers ...string) *Config {
+		config, err := writeConfig(t, dir, strings.Replace("state_dir: DIR\ntailers:"+strings.Join(tailers, ""), "DIR", dir, -1))
+		ensure.Nil(t, err)
+		return config
+	}
+	out := func(name, want string) string {
+		return waitForFile(filepath.Join(dir, name), want)
+	}
+
+	d := NewDaemon(load(tailer("a", "reload", "a.out"), tailer("b", "collect", "b.out"), tailer("c", "collect", "c.out")), log.New(ioutil.Discard, "", 0))
+	d.RestartDelay = time.Millisecond
+	done := make(chan error)
+	go func() { done <- d.Run() }()
+	ensure.DeepEqual(t, out("a.out", "a1\n"), "a1\n")
+	ensure.DeepEqual(t, out("b.out", "b1\n"), "b1\n")
+	ensure.DeepEqual(t, out("c.out", "c1\n"), "c1\n")
+
+	// a stays, b gets a new sink, c goes and d comes
+	d.mu.Lock()
+	a := d.tailers["a"]
+	d.mu.Unlock()
+	atomic.StoreInt32(&reloads, 0)
+	ensure.Nil(t, d.Reload(load(tailer("a", "reload", "a.out"), tailer("b", "collect", "b2.out"), tailer("d", "collect", "d.out"))))
+	d.mu.Lock()
+	ensure.True(t, d.tailers["a"] == a)
+	d.mu.Unlock()
+	ensure.DeepEqual(t, atomic.LoadInt32(&reloads), int32(1))
+	ensure.DeepEqual(t, out("d.out", "d1\n"), "d1\n")
+
+	for _, name := range []string{"a", "b", "c"} {
+		appendLines(t, filepath.Join(dir, name+".log"), name+"2")
+	}
+	ensure.DeepEqual(t, out("a.out", "a1\na2\n"), "a1\na2\n")
+	// b resumes from its checkpoint
+	ensure.DeepEqual(t, out("b2.out", "b2\n"), "b2\n")
+	ensure.DeepEqual(t, out("c.out", "c1\nc2\n"), "c1\n")
+
+	d.Stop()
+	ensure.Nil(t, <-done)
+	// stopping again, as a signal during shutdown would, is harmless
+	ensure.Nil(t, d.Shutdown(context.Background()))
+	stats := d.Stats()
+	ensure.DeepEqual(t, len(stats), 3)
+	ensure.DeepEqual(t, stats["b"].Records, 2)
+}
+
+func TestDaemonReloadBeforeRun(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	appendLines(t, filepath.Join(dir, "a.log"), "a1")
+	config, err := writeConfig(t, dir, strings.Replace(`
+state_dir: DIR
+tailers:
+  - name: a
+    profile: collect
+    log_files: [DIR/a.log]
+    sink: file:DIR/a.out
+`, "DIR", dir, -1))
+	ensure.Nil(t, err)
+
+	// a reload, as on a SIGHUP at startup, waits for Run rather than starting
+	// the tailers a second time
+	d := NewDaemon(config, log.New(ioutil.Discard, "", 0))
+	reloaded := make(chan error)
+	go func() { reloaded <- d.Reload(config) }()
+	select {
+	case err := <-reloaded:
+		t.Fatalf("reload returned before run: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	done := make(chan error)
+	go func() { done <- d.Run() }()
+	ensure.Nil(t, <-reloaded)
+	ensure.DeepEqual(t, waitForFile(filepath.Join(dir, "a.out"), "a1\n"), "a1\n")
+	d.mu.Lock()
+	ensure.DeepEqual(t, len(d.tailers), 1)
+	d.mu.Unlock()
+
+	d.Stop()
+	ensure.Nil(t, <-done)
+	ensure.DeepEqual(t, d.Reload(config), errDaemonStopped)
+}
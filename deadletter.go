@@ -0,0 +1,154 @@
+package logtailer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+)
+
+// A deadLetter is a record that failed to parse, as kept in the dead-letter
+// file.
+type deadLetter struct {
+	Time    time.Time `json:"time"`
+	Profile string    `json:"profile"`
+	Source  string    `json:"source,omitempty"`
+	Offset  int64     `json:"offset"`
+	Line    int64     `json:"line"`
+	Error   string    `json:"error"`
+	Record  string    `json:"record"`
+}
+
+// deadLetter appends r to the dead-letter file, if there is one. Nothing is
+// written during a dry run.
+func (lt *Logtailer) deadLetter(r *record, parseErr error) {
+	lt.writeDeadLetter(&deadLetter{
+		Time:    time.Now(),
+		Profile: lt.Profile.Name(),
+		Source:  r.Source,
+		Offset:  r.Offset,
+		Line:    r.Line,
+		Error:   parseErr.Error(),
+		Record:  r.Text,
+	})
+}
+
+func (lt *Logtailer) writeDeadLetter(dl *deadLetter) {
+	if lt.DeadLetterFile == "" || lt.DryRun {
+		return
+	}
+	buf, err := json.Marshal(dl)
+	if err != nil {
+		lt.Logger.Println("error encoding dead letter:", err)
+		return
+	}
+
+	lt.deadLettersMu.Lock()
+	defer lt.deadLettersMu.Unlock()
+	if lt.deadLetters == nil {
+		f, err := os.OpenFile(lt.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			lt.Logger.Println("error opening dead-letter file:", err)
+			return
+		}
+		lt.deadLetters = f
+	}
+	if _, err := lt.deadLetters.Write(append(buf, '\n')); err != nil {
+		lt.Logger.Println("error writing dead-letter file:", err)
+	}
+}
+
+func (lt *Logtailer) closeDeadLetters() {
+	lt.deadLettersMu.Lock()
+	defer lt.deadLettersMu.Unlock()
+	if lt.deadLetters != nil {
+		lt.deadLetters.Close()
+		lt.deadLetters = nil
+	}
+}
+
+// ReplayDeadLetters runs the records in DeadLetterFile through the profile
+// again, for instance once a parser fix is deployed. Records that still fail to
+// parse are dead-lettered again, and records that were dead-lettered by a
+// different profile are written back unchanged.
+//
+// The file is renamed with a .replaying suffix while it is replayed and removed
+// once every record has been sent without errors. An interrupted replay is
+// picked up by the next call.
+func (lt *Logtailer) ReplayDeadLetters(numWorkers int) (*Stats, error) {
+	stats := &Stats{}
+	if lt.DeadLetterFile == "" {
+		return stats, errors.New("no dead-letter file to replay")
+	}
+	replaying := lt.DeadLetterFile + ".replaying"
+	if _, err := os.Stat(replaying); err == nil {
+		lt.Logger.Println("resuming interrupted replay of", replaying)
+	} else if err := os.Rename(lt.DeadLetterFile, replaying); err != nil {
+		return stats, err
+	}
+	f, err := os.Open(replaying)
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+
+	// finished is set once every record in the file has been handed over
+	finished := false
+	err = lt.process(numWorkers, stats, func(records chan<- *record) {
+		reader := bufio.NewReader(f)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err == io.EOF && len(line) == 0 {
+				finished = true
+				return
+			}
+			if err != nil && err != io.EOF {
+				lt.readFailed(replaying, err)
+				return
+			}
+			var dl deadLetter
+			if err := json.Unmarshal(line, &dl); err != nil {
+				lt.readFailed(replaying, err)
+				return
+			}
+			if dl.Profile != lt.Profile.Name() {
+				lt.writeDeadLetter(&dl)
+				continue
+			}
+			stats.Lock()
+			stats.Records++
+			stats.Unlock()
+			r := &record{Record: profiles.Record{
+				Text:     dl.Record,
+				Source:   dl.Source,
+				Offset:   dl.Offset,
+				Line:     dl.Line,
+				ReadTime: time.Now(),
+				Hostname: hostname,
+			}}
+			if !lt.sequence(r) {
+				return
+			}
+			lt.ledger.read(r)
+			select {
+			case records <- r:
+			case <-lt.shutdown:
+				return
+			}
+		}
+	})
+
+	// records that failed to parse again are in the dead-letter file by now,
+	// so only a failure to deliver the others calls for another replay
+	if !finished || stats.SendErrors > 0 || lt.DryRun {
+		return stats, err
+	}
+	if err := os.Remove(replaying); err != nil {
+		return stats, err
+	}
+	return stats, err
+}
@@ -0,0 +1,117 @@
+package logtailer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+)
+
+// fixedProfile is a slowProfile with its parser fixed.
+type fixedProfile struct {
+	slowProfile
+}
+
+func (p *fixedProfile) ProcessRecord(record string) (interface{}, error) {
+	return record, nil
+}
+
+func readDeadLetters(t *testing.T, path string) []deadLetter {
+	f, err := os.Open(path)
+	ensure.Nil(t, err)
+	defer f.Close()
+	var dls []deadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var dl deadLetter
+		ensure.Nil(t, json.Unmarshal(scanner.Bytes(), &dl))
+		dls = append(dls, dl)
+	}
+	ensure.Nil(t, scanner.Err())
+	return dls
+}
+
+func TestDeadLetters(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	dlq := filepath.Join(dir, "slow.dlq")
+	appendLines(t, logFile, "one", "bad two", "three", "bad four")
+
+	p := &slowProfile{}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.DeadLetterFile = dlq
+	lt.Run(1)
+	ensure.DeepEqual(t, p.output, []string{"one", "three"})
+
+	dls := readDeadLetters(t, dlq)
+	ensure.DeepEqual(t, len(dls), 2)
+	ensure.DeepEqual(t, dls[0].Record, "bad two")
+	ensure.DeepEqual(t, dls[0].Profile, "slow")
+	ensure.DeepEqual(t, dls[0].Source, logFile)
+	ensure.DeepEqual(t, dls[0].Offset, int64(4))
+	ensure.DeepEqual(t, dls[0].Line, int64(2))
+	ensure.DeepEqual(t, dls[0].Error, "bad record")
+	ensure.DeepEqual(t, dls[1].Record, "bad four")
+
+	// replaying with the same parser puts them back
+	lt = NewLogtailerFiles(&slowProfile{}, nil, dir, log.New(ioutil.Discard, "", 0))
+	lt.DeadLetterFile = dlq
+	lt.ReplayDeadLetters(1)
+	ensure.DeepEqual(t, len(readDeadLetters(t, dlq)), 2)
+
+	// replaying with a fixed parser delivers them
+	fixed := &fixedProfile{}
+	lt = NewLogtailerFiles(fixed, nil, dir, log.New(ioutil.Discard, "", 0))
+	lt.DeadLetterFile = dlq
+	stats, err := lt.ReplayDeadLetters(1)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, stats.Records, 2)
+	ensure.DeepEqual(t, fixed.output, []string{"bad two", "bad four"})
+	_, err = os.Stat(dlq)
+	ensure.True(t, os.IsNotExist(err))
+	_, err = os.Stat(dlq + ".replaying")
+	ensure.True(t, os.IsNotExist(err))
+}
+
+func TestReplayDeadLettersOrdered(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	dlq := filepath.Join(dir, "slow.dlq")
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprint("bad", i))
+	}
+	appendLines(t, logFile, lines...)
+
+	lt := NewLogtailerFiles(&slowProfile{}, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.DeadLetterFile = dlq
+	lt.Run(1)
+	ensure.DeepEqual(t, len(readDeadLetters(t, dlq)), len(lines))
+
+	// more records than fit in the reorder window, put back in order
+	fixed := &fixedProfile{}
+	lt = NewLogtailerFiles(fixed, nil, dir, log.New(ioutil.Discard, "", 0))
+	lt.DeadLetterFile = dlq
+	lt.Ordered = true
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := lt.ReplayDeadLetters(4)
+		ensure.Nil(t, err)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("ordered replay did not finish")
+	}
+	ensure.DeepEqual(t, fixed.output, lines)
+}
@@ -1,31 +1,23 @@
 package logtailer
 
-import (
-	"fmt"
-	"os"
-	"os/exec"
-)
+import "os"
 
-// logtailBinary is the name of the binary that will be invoked to fetch new log
-// lines.
-const logtailBinary = "logtail2"
-
-// PrepEnvironment ensures the specified log file and state directories exist.
+// PrepEnvironment ensures the specified log files and state directories exist.
+// Glob patterns may match nothing yet.
 func (lt *Logtailer) PrepEnvironment() error {
-	if lt.LogFile == "-" {
+	logFiles := lt.logFiles()
+	if len(logFiles) == 1 && logFiles[0] == "-" {
 		return nil
 	}
-	_, err := os.Stat(lt.LogFile)
-	if err != nil {
-		return err
-	}
-	os.MkdirAll(lt.StateDir, 0644)
-	_, err = os.Stat(lt.StateDir)
-	if err != nil {
-		return err
-	}
-	if _, err = exec.LookPath(logtailBinary); err != nil {
-		return fmt.Errorf("could not find %s on PATH: %v", logtailBinary, err)
+	for _, path := range logFiles {
+		if isGlob(path) {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
 	}
-	return nil
+	os.MkdirAll(lt.StateDir, 0755)
+	_, err := os.Stat(lt.StateDir)
+	return err
 }
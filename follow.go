@@ -0,0 +1,126 @@
+package logtailer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// pollInterval is how often a followed file is checked for new data.
+var pollInterval = 250 * time.Millisecond
+
+// errStopped ends a followed segment when the tailer is stopped.
+var errStopped = errors.New("logtailer stopped")
+
+// followReader reads a log file like `tail -F`: at EOF it waits for more data
+// and only ends once the file at its path has been replaced or truncated.
+type followReader struct {
+	lt  *Logtailer
+	seg *segment
+	// pos is the number of bytes read from the file, which runs ahead of
+	// seg.offset by whatever the scanner has buffered.
+	pos int64
+}
+
+// follow makes seg wait for new data at EOF.
+func (lt *Logtailer) follow(seg *segment) {
+	seg.Reader = &followReader{lt: lt, seg: seg, pos: seg.offset}
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		if r.rotated() {
+			// pick up anything written before the rotation
+			return r.read(p)
+		}
+		select {
+		case <-r.lt.shutdown:
+			r.seg.stopped = true
+			return 0, errStopped
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// rotated reports whether the path now names a different file (rename
+// rotation) or the file has been truncated (copytruncate rotation).
+func (r *followReader) rotated() bool {
+	fi, err := os.Stat(r.seg.path)
+	if err != nil {
+		// moved away and not yet recreated, keep reading the old file
+		return false
+	}
+	if inode(fi) != r.seg.id.Inode || fi.Size() < r.pos {
+		return true
+	}
+	// a truncated file may have been written past our position already, but
+	// its head will differ from what was read of it
+	head, err := readHead(r.seg.file)
+	return err != nil || !r.seg.id.matchesHead(head)
+}
+
+// read reads from the file, widening its identity with what is read of its
+// first headSize bytes, so that rotated compares against content that was
+// actually read rather than whatever the file holds when checked.
+func (r *followReader) read(p []byte) (int, error) {
+	n, err := r.seg.file.Read(p)
+	if head := r.seg.head; r.pos == int64(len(head)) && len(head) < headSize && n > 0 {
+		b := p[:n]
+		if len(b) > headSize-len(head) {
+			b = b[:headSize-len(head)]
+		}
+		head = append(head[:len(head):len(head)], b...)
+		sum := sha1.Sum(head)
+		r.seg.head = head
+		r.seg.id.HeadLen = len(head)
+		r.seg.id.Head = hex.EncodeToString(sum[:])
+	}
+	r.pos += int64(n)
+	return n, err
+}
+
+// reopen waits for t's file to exist and returns a followed segment over it,
+// starting from the beginning. It returns nil if the tailer is stopped.
+func (lt *Logtailer) reopen(t *tail) *segment {
+	for {
+		seg, err := openSegment(t.path)
+		if err == nil {
+			lt.Logger.Printf("%s was rotated, reopening", t.path)
+			lt.follow(seg)
+			return seg
+		}
+		select {
+		case <-lt.shutdown:
+			return nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// checkpointPeriodically saves the checkpoint every CheckpointInterval until
+// done is closed, as long as delivered reports that the records it is past
+// have been delivered.
+func (lt *Logtailer) checkpointPeriodically(done <-chan struct{}, delivered func() bool) {
+	ticker := time.NewTicker(lt.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !delivered() {
+				continue
+			}
+			if err := lt.saveCheckpoint(); err != nil {
+				lt.Logger.Println("error saving checkpoint:", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
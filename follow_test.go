@@ -0,0 +1,259 @@
+package logtailer
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+)
+
+// waitForLines polls p until it has collected n lines or a second has passed.
+func waitForLines(p *collectProfile, n int) []string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.Lock()
+		lines := append([]string(nil), p.lines...)
+		p.Unlock()
+		if len(lines) >= n || time.Now().After(deadline) {
+			return lines
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFollowRotation(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1")
+
+	p := &collectProfile{}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Follow = true
+	done := make(chan error)
+	go func() {
+		_, err := lt.Run(1)
+		done <- err
+	}()
+
+	ensure.DeepEqual(t, waitForLines(p, 1), []string{"1"})
+	appendLines(t, logFile, "2")
+	ensure.DeepEqual(t, waitForLines(p, 2), []string{"1", "2"})
+
+	// rename rotation
+	ensure.Nil(t, os.Rename(logFile, logFile+".1"))
+	appendLines(t, logFile+".1", "3")
+	appendLines(t, logFile, "4")
+	ensure.DeepEqual(t, waitForLines(p, 4), []string{"1", "2", "3", "4"})
+
+	// copytruncate rotation
+	ensure.Nil(t, os.Truncate(logFile, 0))
+	appendLines(t, logFile, "5")
+	ensure.DeepEqual(t, waitForLines(p, 5), []string{"1", "2", "3", "4", "5"})
+
+	lt.Stop()
+	ensure.Nil(t, <-done)
+	appendLines(t, logFile, "6")
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"6"})
+}
+
+func TestFollowTruncatedAndRegrown(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	ensure.Nil(t, ioutil.WriteFile(logFile, nil, 0644))
+
+	seg, err := openSegment(logFile)
+	ensure.Nil(t, err)
+	defer seg.Close()
+	lt := NewLogtailerFiles(&collectProfile{}, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.follow(seg)
+	r := seg.Reader.(*followReader)
+
+	ensure.False(t, r.rotated())
+	appendLines(t, logFile, "1")
+	buf := make([]byte, 10)
+	n, err := r.Read(buf)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(buf[:n]), "1\n")
+
+	// copytruncate, with the file written past our position before the next
+	// check
+	ensure.Nil(t, os.Truncate(logFile, 0))
+	appendLines(t, logFile, "2", "3")
+	ensure.True(t, r.rotated())
+}
+
+func TestFollowGlob(t *testing.T) {
+	defer func(p, d time.Duration) { pollInterval, discoverInterval = p, d }(pollInterval, discoverInterval)
+	pollInterval = time.Millisecond
+	discoverInterval = time.Millisecond
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	appendLines(t, filepath.Join(dir, "a.log"), "a1")
+
+	p := &collectProfile{}
+	lt := NewLogtailerFiles(p, []string{filepath.Join(dir, "*.log")}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Follow = true
+	done := make(chan error)
+	go func() {
+		_, err := lt.Run(1)
+		done <- err
+	}()
+
+	ensure.DeepEqual(t, waitForLines(p, 1), []string{"a1"})
+	appendLines(t, filepath.Join(dir, "b.log"), "b1")
+	ensure.DeepEqual(t, waitForLines(p, 2), []string{"a1", "b1"})
+
+	// a new file that cannot be opened yet is tried again
+	stateFile := lt.stateFilePath(filepath.Join(dir, "c.log"))
+	ensure.Nil(t, ioutil.WriteFile(stateFile, []byte("unreadable"), 0644))
+	appendLines(t, filepath.Join(dir, "c.log"), "c1")
+	time.Sleep(20 * time.Millisecond)
+	ensure.Nil(t, os.Remove(stateFile))
+	ensure.DeepEqual(t, waitForLines(p, 3), []string{"a1", "b1", "c1"})
+
+	lt.Stop()
+	ensure.Nil(t, <-done)
+	appendLines(t, filepath.Join(dir, "a.log"), "a2")
+	appendLines(t, filepath.Join(dir, "b.log"), "b2")
+	lines := tailOnce(t, filepath.Join(dir, "*.log"), dir)
+	ensure.DeepEqual(t, len(lines), 2)
+}
+
+// drainProfile holds on to its output until records is closed, then waits for
+// release before keeping it, like a profile with buffered output.
+type drainProfile struct {
+	collectProfile
+	release chan struct{}
+	output  []string
+}
+
+func (p *drainProfile) HandleOutput(records <-chan interface{}, dryRun bool) <-chan error {
+	errChan := make(chan error)
+	go func() {
+		defer close(errChan)
+		var buffered []string
+		for r := range records {
+			buffered = append(buffered, r.(string))
+		}
+		<-p.release
+		p.output = buffered
+	}()
+	return errChan
+}
+
+func TestShutdown(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1", "2")
+
+	p := &drainProfile{release: make(chan struct{})}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Follow = true
+	done := make(chan error)
+	go func() {
+		_, err := lt.Run(1)
+		done <- err
+	}()
+	ensure.DeepEqual(t, waitForLines(&p.collectProfile, 2), []string{"1", "2"})
+
+	// the output is not done by the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ensure.DeepEqual(t, lt.Shutdown(ctx), context.DeadlineExceeded)
+
+	// but Run still finishes it
+	close(p.release)
+	ensure.Nil(t, <-done)
+	ensure.DeepEqual(t, p.output, []string{"1", "2"})
+	appendLines(t, logFile, "3")
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"3"})
+}
+
+func TestRunContext(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1", "2")
+
+	p := &collectProfile{}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Follow = true
+	ctx, cancel := context.WithCancel(context.Background())
+	type result struct {
+		stats *Stats
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		stats, err := lt.RunContext(ctx, RunOptions{NumWorkers: 2})
+		done <- result{stats, err}
+	}()
+	ensure.DeepEqual(t, len(waitForLines(p, 2)), 2)
+
+	cancel()
+	r := <-done
+	ensure.DeepEqual(t, r.err, context.Canceled)
+	ensure.DeepEqual(t, r.stats.Records, 2)
+	// stopping again is harmless, running again is not allowed
+	lt.Stop()
+	_, err := lt.RunContext(context.Background(), RunOptions{})
+	ensure.DeepEqual(t, err, ErrStopped)
+	appendLines(t, logFile, "3")
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"3"})
+}
+
+func TestRunContextDrainTimeout(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1")
+
+	p := &drainProfile{release: make(chan struct{})}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Follow = true
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	stats, err := lt.RunContext(ctx, RunOptions{DrainTimeout: 10 * time.Millisecond})
+	ensure.DeepEqual(t, err, context.DeadlineExceeded)
+	ensure.DeepEqual(t, stats.Records, 1)
+
+	// the run finishes in the background
+	close(p.release)
+	ensure.Nil(t, lt.Shutdown(context.Background()))
+	ensure.DeepEqual(t, p.output, []string{"1"})
+}
+
+func TestRunContextUnhealthy(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1", "bad2", "bad3")
+
+	lt := NewLogtailerFiles(&slowProfile{}, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	stats, err := lt.RunContext(context.Background(), RunOptions{})
+	unhealthy, ok := err.(*UnhealthyError)
+	ensure.True(t, ok)
+	ensure.DeepEqual(t, unhealthy.Stats, stats)
+	ensure.DeepEqual(t, stats.ParseErrors, 2)
+}
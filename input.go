@@ -0,0 +1,220 @@
+package logtailer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// A segment is a contiguous run of unconsumed input from a single file.
+type segment struct {
+	io.Reader
+	// file is nil for stdin, which is never checkpointed.
+	file *os.File
+	// decoder is set when the file is compressed.
+	decoder io.Closer
+	path    string
+	id      fileID
+	// head is the start of the file's content, used to recognize it.
+	head []byte
+	// offset is the position in the content of the next unread byte, and line
+	// the number of lines before it.
+	offset int64
+	line   int64
+	// tokenOffset and tokenLine locate the start of the last token read, and
+	// truncated is set if it is the start of an oversized record.
+	tokenOffset int64
+	tokenLine   int64
+	truncated   bool
+	// stopped is set when a followed segment ends because the tailer stopped
+	// rather than because the file was rotated.
+	stopped bool
+}
+
+// getInput returns the segments of t's file that have not been consumed yet,
+// in the order they should be read.
+//
+// The checkpoint left by the previous run decides where reading starts. If the
+// log file was truncated it is read from the beginning. If it was rotated, the
+// rotated file is found among its siblings and read from the checkpoint,
+// followed by every file rotated after it and finally the live file.
+//
+// In follow mode the last segment waits for the file to grow instead of
+// ending at EOF.
+func (lt *Logtailer) getInput(t *tail) ([]*segment, error) {
+	input, err := lt.resume(t)
+	if err != nil || !lt.Follow || t.path == "-" {
+		return input, err
+	}
+	lt.follow(input[len(input)-1])
+	return input, nil
+}
+
+func (lt *Logtailer) resume(t *tail) ([]*segment, error) {
+	if t.path == "-" {
+		return []*segment{{Reader: os.Stdin}}, nil
+	}
+
+	cp, err := t.loadCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	live, err := openSegment(t.path)
+	if err != nil {
+		return nil, err
+	}
+	if cp == nil {
+		return []*segment{live}, nil
+	}
+
+	if cp.sameFile(live) {
+		if size, err := live.size(); err == nil && size < cp.Offset {
+			lt.Logger.Printf("%s was truncated, reading from the start", t.path)
+			return []*segment{live}, nil
+		}
+		if err := live.skip(cp); err != nil {
+			live.Close()
+			return nil, err
+		}
+		return []*segment{live}, nil
+	}
+
+	lt.Logger.Printf("%s was rotated since the last run", t.path)
+	rotated, err := lt.catchUp(t.path, cp)
+	if err != nil {
+		live.Close()
+		return nil, err
+	}
+	return append(rotated, live), nil
+}
+
+// catchUp returns the segments of the rotated siblings of path that were
+// written after the checkpoint, oldest first.
+func (lt *Logtailer) catchUp(path string, cp *checkpoint) ([]*segment, error) {
+	siblings, err := rotatedSiblings(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// look for the file the checkpoint was taken from, newest first
+	var input []*segment
+	for i := len(siblings) - 1; i >= 0; i-- {
+		seg, err := openSegment(siblings[i])
+		if err != nil {
+			lt.Logger.Println("error opening rotated file:", err)
+			continue
+		}
+		if !cp.sameContent(seg) {
+			input = append([]*segment{seg}, input...)
+			continue
+		}
+		if err := seg.skip(cp); err != nil {
+			lt.Logger.Printf("error resuming %s: %v", seg.path, err)
+			seg.Close()
+			return input, nil
+		}
+		return append([]*segment{seg}, input...), nil
+	}
+
+	// the checkpointed file is gone, so replay what was rotated after the
+	// checkpoint was saved
+	lt.Logger.Printf("rotated file for %s checkpoint not found, some lines may be lost", path)
+	if cp.Time.IsZero() {
+		// logtail2 checkpoints do not say when they were saved, and replaying
+		// every rotated file would repeat the whole history
+		for _, seg := range input {
+			seg.Close()
+		}
+		return nil, nil
+	}
+	var replay []*segment
+	for _, seg := range input {
+		if fi, err := seg.file.Stat(); err == nil && fi.ModTime().After(cp.Time) {
+			replay = append(replay, seg)
+		} else {
+			seg.Close()
+		}
+	}
+	return replay, nil
+}
+
+// openSegment opens the file at path for reading from the beginning,
+// decompressing it if its name ends in .gz or .zst.
+func openSegment(path string) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	seg := &segment{Reader: f, file: f, path: path}
+
+	decoder, err := newDecoder(path, f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error decompressing %s: %v", path, err)
+	}
+	if decoder != nil {
+		seg.decoder = decoder
+		buf := make([]byte, headSize)
+		n, err := io.ReadFull(decoder, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			seg.Close()
+			return nil, fmt.Errorf("error decompressing %s: %v", path, err)
+		}
+		seg.head = buf[:n]
+		seg.Reader = io.MultiReader(bytes.NewReader(seg.head), decoder)
+	} else if seg.head, err = readHead(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	seg.id = identify(fi, seg.head)
+	return seg, nil
+}
+
+// position returns a checkpoint covering everything consumed from the segment,
+// or nil for stdin.
+func (s *segment) position() *checkpoint {
+	if s.file == nil {
+		return nil
+	}
+	return &checkpoint{fileID: s.id, Offset: s.offset, Line: s.line}
+}
+
+// skip moves past the content of the segment covered by cp.
+func (s *segment) skip(cp *checkpoint) error {
+	if s.decoder != nil {
+		if _, err := io.CopyN(ioutil.Discard, s.Reader, cp.Offset); err != nil {
+			return err
+		}
+	} else if _, err := s.file.Seek(cp.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	s.offset, s.line = cp.Offset, cp.Line
+	return nil
+}
+
+func (s *segment) size() (int64, error) {
+	fi, err := s.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Close closes the underlying file, if any.
+func (s *segment) Close() error {
+	if s.decoder != nil {
+		s.decoder.Close()
+	}
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,92 @@
+package logtailer
+
+import "sync"
+
+// A ledger follows records from when they are read until they are done with,
+// and advances the checkpoint of each file past records that are done along
+// with every record read from the file before them.
+//
+// A record is done when its output has been acknowledged, or when it produced
+// no output. Records handed to a profile that does not acknowledge its output
+// are done as soon as they are handed over.
+type ledger struct {
+	mu sync.Mutex
+	// unfinished holds the records read from each file that are not known to
+	// be done yet, in the order they were read.
+	unfinished map[*tail][]*record
+	// sent holds the records handed to the output that await acknowledgement,
+	// in the order they were sent.
+	sent []*record
+}
+
+func newLedger() *ledger {
+	return &ledger{unfinished: make(map[*tail][]*record)}
+}
+
+// read registers a record that has just been read from its file.
+func (l *ledger) read(r *record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.unfinished[r.tail] = append(l.unfinished[r.tail], r)
+	l.advance(r.tail)
+}
+
+// sending registers a record that is about to be handed to the output.
+func (l *ledger) sending(r *record) {
+	l.mu.Lock()
+	l.sent = append(l.sent, r)
+	l.mu.Unlock()
+}
+
+// done marks a record as done.
+func (l *ledger) done(r *record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.done = true
+	l.advance(r.tail)
+}
+
+// ack marks the next n records sent to the output as done.
+func (l *ledger) ack(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n > len(l.sent) {
+		n = len(l.sent)
+	}
+	acked := l.sent[:n]
+	l.sent = l.sent[n:]
+	for _, r := range acked {
+		r.done = true
+	}
+	for _, r := range acked {
+		l.advance(r.tail)
+	}
+}
+
+// pending returns how many records have been read but are not done yet, and
+// how many of them await acknowledgement from the output.
+func (l *ledger) pending() (unfinished, sent int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, queue := range l.unfinished {
+		for _, r := range queue {
+			if !r.done {
+				unfinished++
+			}
+		}
+	}
+	return unfinished, len(l.sent)
+}
+
+// advance moves the checkpoint of t past the done records at the front of its
+// queue. The caller must hold l.mu.
+func (l *ledger) advance(t *tail) {
+	queue := l.unfinished[t]
+	for len(queue) > 0 && queue[0].done {
+		if queue[0].end != nil {
+			t.setPosition(queue[0].end)
+		}
+		queue = queue[1:]
+	}
+	l.unfinished[t] = queue
+}
@@ -0,0 +1,193 @@
+package logtailer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/sinks"
+	"github.com/facebookgo/ensure"
+)
+
+// failingProfile acknowledges records until it sees failOn, which it fails to
+// send along with everything after it.
+type failingProfile struct {
+	collectProfile
+	failOn string
+}
+
+func (p *failingProfile) HandleOutputAck(records <-chan interface{}, dryRun bool, ack func(n int)) <-chan error {
+	errChan := make(chan error)
+	go func() {
+		defer close(errChan)
+		failed := false
+		for record := range records {
+			if failed = failed || record.(string) == p.failOn; failed {
+				errChan <- errors.New("downstream unavailable")
+				continue
+			}
+			ack(1)
+		}
+	}()
+	return errChan
+}
+
+func TestCheckpointOnlyPastAcknowledged(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1", "2", "3", "4")
+
+	p := &failingProfile{failOn: "3"}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	stats, err := lt.Run(1)
+	ensure.NotNil(t, err)
+	ensure.True(t, stats.SendErrors > 0)
+
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"3", "4"})
+}
+
+func TestSendErrorEndsFollowedRun(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1", "2", "3")
+
+	p := &failingProfile{failOn: "2"}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Follow = true
+	done := make(chan error)
+	go func() {
+		_, err := lt.Run(1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		ensure.NotNil(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("run went on after failing to send")
+	}
+
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"2", "3"})
+}
+
+// unsentProfile fails to send every record, without acknowledging any.
+type unsentProfile struct {
+	collectProfile
+}
+
+func (p *unsentProfile) HandleOutput(records <-chan interface{}, dryRun bool) <-chan error {
+	errChan := make(chan error)
+	go func() {
+		defer close(errChan)
+		for range records {
+			errChan <- errors.New("downstream unavailable")
+		}
+	}()
+	return errChan
+}
+
+func TestSendErrorKeepsPeriodicCheckpoint(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1", "2", "3")
+
+	lt := NewLogtailerFiles(&unsentProfile{}, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Follow = true
+	lt.CheckpointInterval = 10 * time.Millisecond
+	done := make(chan error)
+	go func() {
+		_, err := lt.Run(1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		ensure.NotNil(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("run went on after failing to send")
+	}
+
+	ensure.DeepEqual(t, tailOnce(t, logFile, dir), []string{"1", "2", "3"})
+}
+
+func TestSinkReplacesOutput(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	out := filepath.Join(dir, "out")
+	appendLines(t, logFile, "1", "2")
+
+	lt := NewLogtailerFiles(&collectProfile{}, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Sink = sinks.NewFile(out)
+	_, err := lt.Run(1)
+	ensure.Nil(t, err)
+	buf, err := ioutil.ReadFile(out)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(buf), "1\n2\n")
+
+	ensure.DeepEqual(t, len(tailOnce(t, logFile, dir)), 0)
+}
+
+// unencodableProfile outputs a record for "bad" that cannot be marshaled.
+type unencodableProfile struct {
+	collectProfile
+}
+
+func (p *unencodableProfile) ProcessRecord(record string) (interface{}, error) {
+	if record == "bad" {
+		return make(chan int), nil
+	}
+	return p.collectProfile.ProcessRecord(record)
+}
+
+func TestUnencodableRecordIsParseError(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	out := filepath.Join(dir, "out")
+	lines := []string{"bad"}
+	for i := 0; i < 20; i++ {
+		lines = append(lines, fmt.Sprint(i))
+	}
+	appendLines(t, logFile, lines...)
+
+	lt := NewLogtailerFiles(&unencodableProfile{}, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Sink = sinks.NewFile(out)
+	stats, err := lt.Run(1)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, stats.ParseErrors, 1)
+	ensure.DeepEqual(t, stats.SendErrors, 0)
+	buf, err := ioutil.ReadFile(out)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(buf), strings.Join(lines[1:], "\n")+"\n")
+	ensure.DeepEqual(t, len(tailOnce(t, logFile, dir)), 0)
+}
+
+func TestFailedSinkClosesInputs(t *testing.T) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count open files:", err)
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1")
+
+	before := len(fds)
+	for i := 0; i < 10; i++ {
+		lt := NewLogtailerFiles(&collectProfile{}, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+		lt.Sink = sinks.NewUnix(filepath.Join(dir, "no-collector.sock"))
+		_, err := lt.Run(1)
+		ensure.NotNil(t, err)
+	}
+	fds, err = ioutil.ReadDir("/proc/self/fd")
+	ensure.Nil(t, err)
+	ensure.True(t, len(fds) < before+10, before, len(fds))
+}
@@ -3,28 +3,105 @@ package logtailer
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/ParsePlatform/logtailer/sinks"
 )
 
 // Logtailer holds the state of a logtailer program which represents the
 // consumption of an input source with a particular profile
 type Logtailer struct {
-	Logger   *log.Logger
-	Profile  profiles.Profile
-	LogFile  string
+	Logger  *log.Logger
+	Profile profiles.Profile
+	// LogFile is the path of the log file to consume, "-" for stdin.
+	LogFile string
+	// LogFiles are the paths of more log files to consume, along with
+	// LogFile. They may be glob patterns, which in follow mode also pick up
+	// matching files created later.
+	LogFiles []string
 	StateDir string
 	DryRun   bool
+	// Follow keeps the tailer waiting for new lines at the end of the log file,
+	// across rotations, until Stop is called.
+	Follow bool
+	// CheckpointInterval is how often the checkpoint is saved in follow mode.
+	CheckpointInterval time.Duration
+	// MaxRecordSize is the size in bytes of the largest record handed to the
+	// profile, and OversizePolicy decides what happens to larger ones.
+	MaxRecordSize  int
+	OversizePolicy OversizePolicy
+	// DeadLetterFile is the path of a file that records which fail to parse
+	// are appended to, one JSON object per line, for ReplayDeadLetters to
+	// process again later. They are only logged if it is empty.
+	DeadLetterFile string
+	// Sink, if set, receives the output of the profile in place of its
+	// HandleOutput, except for profiles.SinkProfile profiles, which are handed
+	// the sink to write to themselves.
+	Sink sinks.Sink
+	// SpoolDir, if set, is where output for the Sink is kept on disk until the
+	// sink has delivered it, see sinks.Spool.
+	SpoolDir string
+	// Ordered hands the output of the profile to HandleOutput in the order the
+	// records were read, even when they are processed by several workers.
+	Ordered bool
 
-	shutdown   chan struct{}
-	logtailCmd *exec.Cmd
+	shutdown chan struct{}
+	stopOnce sync.Once
+	// running is closed once the current run has returned, nil between runs.
+	// stats are those of the last run, and latency times each of its workers.
+	running   chan struct{}
+	stats     *Stats
+	latency   []*histogram
+	runningMu sync.Mutex
+	// tails holds the progress through each log file by path.
+	tails   map[string]*tail
+	tailsMu sync.Mutex
+	ledger  *ledger
+	// readErr is the first error reading the input during a run.
+	readErr   error
+	readErrMu sync.Mutex
+	// seq numbers the records read in ordered mode, and window limits how far
+	// reading gets ahead of the output.
+	seq    uint64
+	window chan struct{}
+	// deadLetters is the open DeadLetterFile, if any.
+	deadLetters   *os.File
+	deadLettersMu sync.Mutex
+}
+
+// record is a single token read from the input.
+type record struct {
+	profiles.Record
+	tail *tail
+	// end is the checkpoint just past the record, nil for stdin.
+	end *checkpoint
+	// seq is the position of the record in the input in ordered mode.
+	seq uint64
+	// result is the output of the profile for the record, and failed is set if
+	// there is none because it could not be parsed.
+	result interface{}
+	failed bool
+	// done is guarded by the ledger.
+	done bool
+}
+
+// hostname is the name of the host logtailer runs on.
+var hostname string
+
+func init() {
+	var err error
+	if hostname, err = os.Hostname(); err != nil {
+		hostname = "unknown"
+	}
 }
 
 // Splitter supplies a custom function for a bufio.Scanner
@@ -35,139 +112,555 @@ type Splitter interface {
 // NewLogtailer prepares a new Logtailer from a profile, input logfile, state
 // directory, and a logger.
 func NewLogtailer(profile profiles.Profile, logFile string, stateDir string, logger *log.Logger) *Logtailer {
+	lt := NewLogtailerFiles(profile, nil, stateDir, logger)
+	lt.LogFile = logFile
+	return lt
+}
+
+// NewLogtailerFiles is NewLogtailer for several log files or glob patterns.
+func NewLogtailerFiles(profile profiles.Profile, logFiles []string, stateDir string, logger *log.Logger) *Logtailer {
 	return &Logtailer{
 		Logger:   logger,
 		Profile:  profile,
-		LogFile:  logFile,
+		LogFiles: logFiles,
 		StateDir: stateDir,
 		shutdown: make(chan struct{}),
+		tails:    make(map[string]*tail),
+
+		CheckpointInterval: 10 * time.Second,
+		MaxRecordSize:      DefaultMaxRecordSize,
+		OversizePolicy:     TruncateOversize,
 	}
 }
 
-func (lt *Logtailer) getInput() (io.Reader, error) {
-	if lt.LogFile == "-" {
-		return os.Stdin, nil
-	}
+// Run starts the consumption of the input source and starts `numWorkers`
+// separate goroutines to process lines. It returns once the input is exhausted,
+// or in follow mode once Stop is called.
+//
+// Records are processed in parallel, so their output reaches HandleOutput out
+// of order unless `numWorkers` is 1 or Ordered is set. Profiles that implement
+// Sharder keep the order of records with the same shard key.
+//
+// Checkpoints only advance past records whose output has been acknowledged,
+// see profiles.AckingProfile, and the first error sending them stops the run
+// as Stop does. Records sent to a profile that does not acknowledge its output
+// count as delivered once handed over, so once any of them failed to send no
+// checkpoint is saved, periodically or at the end of the run.
+//
+// Errors reading the input are returned as a *ReadError, and a run with too
+// many errors as an *UnhealthyError. A Logtailer cannot be run again once it
+// has been stopped, Run then returns ErrStopped.
+func (lt *Logtailer) Run(numWorkers int) (*Stats, error) {
+	stats := &Stats{}
+	return stats, lt.run(numWorkers, stats)
+}
 
-	lt.newLogtailCmd()
+// RunOptions are the options of RunContext.
+type RunOptions struct {
+	// NumWorkers is the number of goroutines processing records, 1 if zero.
+	NumWorkers int
+	// DrainTimeout is how long RunContext waits, once ctx is done, for the
+	// records already read to be delivered before returning anyway. Zero waits
+	// for as long as it takes.
+	DrainTimeout time.Duration
+}
 
-	// set up output pipeline.
-	stdout, err := lt.logtailCmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("error connecting to logtail stdout: %v", err)
+// RunContext is Run, stopping as Stop does once ctx is done. If ctx ends the
+// run, RunContext returns ctx.Err() after the records already read have been
+// through the profile and its output and the checkpoint has been saved, or
+// once opts.DrainTimeout has passed, leaving the run to finish in the
+// background. The stats returned are then a snapshot. As the Logtailer stays
+// stopped, it cannot be run again after that.
+func (lt *Logtailer) RunContext(ctx context.Context, opts RunOptions) (*Stats, error) {
+	numWorkers := opts.NumWorkers
+	if numWorkers < 1 {
+		numWorkers = 1
 	}
-	lt.logtailCmd.Stderr = os.Stderr
-	if err := lt.logtailCmd.Start(); err != nil {
-		return nil, fmt.Errorf("error starting logtail cmd: %v", err)
+	stats := &Stats{}
+	done := make(chan error, 1)
+	go func() {
+		done <- lt.run(numWorkers, stats)
+	}()
+
+	select {
+	case err := <-done:
+		return stats, err
+	case <-ctx.Done():
 	}
+	lt.Stop()
 
-	return stdout, nil
+	var timeout <-chan time.Time
+	if opts.DrainTimeout > 0 {
+		timer := time.NewTimer(opts.DrainTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			err = ctx.Err()
+		}
+		return stats, err
+	case <-timeout:
+		snapshot := &Stats{}
+		snapshot.add(stats)
+		return snapshot, ctx.Err()
+	}
 }
 
-// Run starts the consumption of the input source and starts `numWorkers`
-// separate goroutines to process lines.
-//
-// If the log lines are ordered `numWorkers` should be 1.
-func (lt *Logtailer) Run(numWorkers int) (*Stats, error) {
-	input, err := lt.getInput()
-	stats := &Stats{}
+// run is Run counting into stats, which may be read while it runs.
+func (lt *Logtailer) run(numWorkers int, stats *Stats) error {
+	if _, err := ParseOversizePolicy(string(lt.OversizePolicy)); err != nil {
+		return err
+	}
+	if lt.MaxRecordSize < 1 {
+		return fmt.Errorf("max record size must be at least 1, not %d", lt.MaxRecordSize)
+	}
+	var inputs []*tailInput
+	for _, path := range lt.expandLogFiles() {
+		t := lt.newTail(path)
+		input, err := lt.getInput(t)
+		if err != nil {
+			lt.Logger.Println("error getting logtail input:", err)
+			for _, in := range inputs {
+				in.close()
+			}
+			return err
+		}
+		lt.track(t)
+		inputs = append(inputs, &tailInput{t, input})
+	}
 
-	if err != nil {
-		lt.Logger.Println("error getting logtail input:", err)
-		return stats, err
+	// the scanners close the inputs, unless the run fails before they start
+	started := false
+	defer func() {
+		if !started {
+			for _, in := range inputs {
+				in.close()
+			}
+		}
+	}()
+	return lt.process(numWorkers, stats, func(records chan<- *record) {
+		started = true
+		// start a scanner goroutine per file
+		var scanners sync.WaitGroup
+		for _, in := range inputs {
+			scanners.Add(1)
+			go func(in *tailInput) {
+				defer scanners.Done()
+				lt.scan(in.tail, in.segments, records, stats)
+			}(in)
+		}
+		if lt.Follow {
+			scanners.Add(1)
+			go func() {
+				defer scanners.Done()
+				lt.discover(records, stats, &scanners)
+			}()
+		}
+		scanners.Wait()
+	})
+}
+
+// process runs the records produced by feed through the profile with
+// `numWorkers` workers, and saves the checkpoint once they are done with.
+func (lt *Logtailer) process(numWorkers int, stats *Stats, feed func(records chan<- *record)) error {
+	select {
+	case <-lt.shutdown:
+		return ErrStopped
+	default:
+	}
+	running := make(chan struct{})
+	lt.runningMu.Lock()
+	lt.running = running
+	lt.stats = stats
+	lt.ledger = newLedger()
+	lt.window = make(chan struct{}, numWorkers*reorderWindow)
+	lt.latency = make([]*histogram, numWorkers)
+	for i := range lt.latency {
+		lt.latency[i] = newHistogram()
 	}
-	scanner := bufio.NewScanner(input)
-	inputRecords := make(chan string)
+	lt.runningMu.Unlock()
+	defer func() {
+		lt.runningMu.Lock()
+		lt.running = nil
+		lt.runningMu.Unlock()
+		close(running)
+	}()
+
+	lt.readErr = nil
+	lt.seq = 0
+	inputRecords := make(chan *record)
+	parsedRecords := make(chan *record)
 	outputRecords := make(chan interface{})
 
 	// run any initialization routines needed by the profile
-	err = lt.Profile.Init()
+	err := lt.Profile.Init()
 	if err != nil {
-		return stats, err
+		return err
 	}
+	defer lt.closeDeadLetters()
 
-	// start scanner goroutine
-	go func() {
-		defer close(inputRecords)
-		// if the profile supplies a custom splitting function, use it
-		if splitter, ok := lt.Profile.(Splitter); ok {
-			scanner.Split(splitter.Split)
+	// a dry run only prints the output
+	sink := lt.Sink
+	if sink != nil && lt.DryRun {
+		sink = sinks.NewStdout()
+	} else if sink != nil && lt.SpoolDir != "" {
+		spool := sinks.NewSpool(lt.SpoolDir, sink)
+		spool.Logger = lt.Logger
+		sink = spool
+	}
+	if sink != nil {
+		if err := sink.Open(); err != nil {
+			return err
 		}
-
-		for scanner.Scan() {
-			// hand every token to inputRecords to be consumed by the profile
-			stats.Records++
-			select {
-			case inputRecords <- scanner.Text():
-			case <-lt.shutdown:
-				return
+		defer func() {
+			if err := sink.Close(); err != nil {
+				lt.Logger.Println("error closing sink:", err)
 			}
-		}
+		}()
+	}
+
+	go func() {
+		feed(inputRecords)
+		close(inputRecords)
 	}()
+
+	// with a Sharder, records with the same key always go to the same worker
+	workerRecords := make([]<-chan *record, numWorkers)
+	for i := range workerRecords {
+		workerRecords[i] = inputRecords
+	}
+	if sharder, ok := lt.Profile.(Sharder); ok && numWorkers > 1 {
+		shards := make([]chan *record, numWorkers)
+		for i := range shards {
+			shards[i] = make(chan *record)
+			workerRecords[i] = shards[i]
+		}
+		go dispatch(sharder, inputRecords, shards)
+	}
+
 	var wg sync.WaitGroup
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
-		go func() {
+		go func(records <-chan *record, latency *histogram) {
 			defer wg.Done()
-			for {
-				line, ok := <-inputRecords
-				if !ok {
-					return
+			for line := range records {
+				var record interface{}
+				var err error
+				start := time.Now()
+				if full, ok := lt.Profile.(profiles.RecordProfile); ok {
+					record, err = full.ProcessFullRecord(&line.Record)
+				} else {
+					record, err = lt.Profile.ProcessRecord(line.Text)
 				}
-				record, err := lt.Profile.ProcessRecord(line)
+				latency.observe(time.Since(start))
 
 				if err != nil {
-					lt.Logger.Println("error parsing:", err)
+					lt.Logger.Printf("error parsing %s:%d: %v", line.Source, line.Line, err)
 					stats.Lock()
 					stats.ParseErrors++
 					stats.Unlock()
+					lt.deadLetter(line, err)
+					lt.ledger.done(line)
+					if lt.Ordered {
+						// let the reorder buffer know not to wait for it
+						line.failed = true
+						parsedRecords <- line
+					}
 				} else {
-					outputRecords <- record
+					line.result = record
+					parsedRecords <- line
 				}
 			}
-		}()
+		}(workerRecords[i], lt.latency[i])
+	}
+
+	var errorChan <-chan error
+	acker, acking := lt.Profile.(profiles.AckingProfile)
+	sinkProfile, ownsOutput := lt.Profile.(profiles.SinkProfile)
+	if sink != nil && ownsOutput {
+		sinkProfile.SetSink(sink)
+	}
+	switch {
+	case sink != nil && !ownsOutput:
+		acking = true
+		errorChan = sinks.Output(sink, outputRecords, lt.ledger.ack)
+	case acking:
+		errorChan = acker.HandleOutputAck(outputRecords, lt.DryRun, lt.ledger.ack)
+	default:
+		errorChan = lt.Profile.HandleOutput(outputRecords, lt.DryRun)
+	}
+
+	// without acknowledgements the checkpoint may already be past records
+	// that failed to send
+	delivered := func() bool {
+		stats.Lock()
+		defer stats.Unlock()
+		return acking || stats.SendErrors == 0
+	}
+	if lt.Follow {
+		done := make(chan struct{})
+		defer close(done)
+		go lt.checkpointPeriodically(done, delivered)
 	}
 
-	errorChan := lt.Profile.HandleOutput(outputRecords, lt.DryRun)
+	// hand parsed records to the output in a single goroutine so that the
+	// ledger sees them in the order the output receives them
+	go func() {
+		defer close(outputRecords)
+		send := func(r *record) {
+			if r.failed {
+				return
+			}
+			if acking {
+				lt.ledger.sending(r)
+			}
+			outputRecords <- r.result
+			if !acking {
+				lt.ledger.done(r)
+			}
+		}
+		if !lt.Ordered {
+			for r := range parsedRecords {
+				send(r)
+			}
+			return
+		}
+
+		reorder := newReorderBuffer()
+		for r := range parsedRecords {
+			for _, r := range reorder.add(r) {
+				<-lt.window
+				send(r)
+			}
+		}
+		for _, r := range reorder.flush() {
+			send(r)
+		}
+	}()
 
+	outputDone := make(chan struct{})
 	go func() {
+		defer close(outputDone)
 		for err := range errorChan {
+			if _, ok := err.(*sinks.EncodeError); ok {
+				// the record is skipped, like one that fails to parse
+				stats.Lock()
+				stats.ParseErrors++
+				stats.Unlock()
+				lt.Logger.Println(err)
+				continue
+			}
 			stats.Lock()
 			stats.SendErrors++
 			stats.Unlock()
 			lt.Logger.Println("error sending:", err)
+			// the checkpoint moves no further, so end the run rather than
+			// read on without it
+			lt.Stop()
 		}
 	}()
 	wg.Wait()
-	close(outputRecords)
+	close(parsedRecords)
+	<-outputDone
+
+	if !delivered() {
+		lt.Logger.Println("not saving checkpoint after errors sending")
+	} else if err := lt.saveCheckpoint(); err != nil {
+		lt.Logger.Println("error saving checkpoint:", err)
+		return err
+	}
 
-	if stats.IsHealthy() {
-		err = nil
-	} else {
-		err = fmt.Errorf("stats indicate unhealthy run: %+v", stats)
+	if err := lt.readError(); err != nil {
+		return err
 	}
-	return stats, err
+	if !stats.IsHealthy() {
+		return &UnhealthyError{Stats: stats}
+	}
+	return nil
 }
 
-// Stop stops consuming new input
+// Stop stops consuming new input. It may be called more than once.
 func (lt *Logtailer) Stop() {
-	close(lt.shutdown)
+	lt.stopOnce.Do(func() { close(lt.shutdown) })
 }
 
-func (lt *Logtailer) stateFilePath() string {
-	logFileName := filepath.Base(lt.LogFile)
-	fileName := fmt.Sprintf("logtailer-%s-%s.state", lt.Profile.Name(), logFileName)
-	return filepath.Join(lt.StateDir, fileName)
+// Shutdown stops consuming new input, like Stop, and waits for Run to return,
+// which it does once the records already read have been through the profile
+// and its output, the sink has been flushed and closed, and the checkpoint has
+// been saved. If ctx is done first Shutdown returns its error, and Run is left
+// to finish in the background.
+func (lt *Logtailer) Shutdown(ctx context.Context) error {
+	lt.runningMu.Lock()
+	running := lt.running
+	lt.runningMu.Unlock()
+	lt.Stop()
+	if running == nil {
+		return nil
+	}
+	select {
+	case <-running:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (lt *Logtailer) newLogtailCmd() {
-	args := []string{
-		"-f", lt.LogFile,
-		"-o", lt.stateFilePath(),
+// scan reads t's segments in order and hands every token to records until the
+// input is exhausted or the tailer is stopped.
+func (lt *Logtailer) scan(t *tail, input []*segment, records chan<- *record, stats *Stats) {
+	defer func() {
+		for _, seg := range input {
+			seg.Close()
+		}
+		if t.spill != nil {
+			t.spill.Close()
+			t.spill = nil
+		}
+	}()
+
+	for len(input) > 0 {
+		seg := input[0]
+		scanner := lt.newScanner(t, seg, stats)
+		for scanner.Scan() {
+			// hand every token to records to be consumed by the profile
+			stats.Lock()
+			stats.Records++
+			stats.Unlock()
+			r := &record{
+				Record: profiles.Record{
+					Text:      scanner.Text(),
+					Truncated: seg.truncated,
+					Source:    t.path,
+					Offset:    seg.tokenOffset,
+					Line:      seg.tokenLine,
+					ReadTime:  time.Now(),
+					Hostname:  hostname,
+				},
+				tail: t,
+				end:  seg.position(),
+			}
+			seg.truncated = false
+			if !lt.sequence(r) {
+				return
+			}
+			lt.ledger.read(r)
+			select {
+			case records <- r:
+			case <-lt.shutdown:
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			if err != errStopped {
+				lt.Logger.Println("error reading input:", err)
+				lt.readFailed(seg.path, err)
+			}
+			return
+		}
+		// the segment may end with bytes that did not form a token
+		lt.ledger.read(&record{tail: t, end: seg.position(), done: true})
+
+		seg.Close()
+		input = input[1:]
+		if lt.Follow && len(input) == 0 {
+			if next := lt.reopen(t); next != nil {
+				input = append(input, next)
+			}
+		}
 	}
+}
+
+// sequence numbers r in ordered mode, once it has a slot in the reorder
+// window. It returns false if the tailer is stopped while it waits for one.
+func (lt *Logtailer) sequence(r *record) bool {
+	if !lt.Ordered {
+		return true
+	}
+	select {
+	case lt.window <- struct{}{}:
+	case <-lt.shutdown:
+		return false
+	}
+	r.seq = atomic.AddUint64(&lt.seq, 1) - 1
+	return true
+}
+
+// ErrStopped is returned by Run when the Logtailer has already been stopped.
+var ErrStopped = errors.New("logtailer already stopped")
+
+// A ReadError is returned by Run when reading a file failed.
+type ReadError struct {
+	Path string
+	Err  error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("error reading %s: %v", e.Path, e.Err)
+}
+
+// readFailed records an error reading the file at path, to be returned by Run.
+func (lt *Logtailer) readFailed(path string, err error) {
+	lt.readErrMu.Lock()
+	if lt.readErr == nil {
+		lt.readErr = &ReadError{Path: path, Err: err}
+	}
+	lt.readErrMu.Unlock()
+}
+
+func (lt *Logtailer) readError() error {
+	lt.readErrMu.Lock()
+	defer lt.readErrMu.Unlock()
+	return lt.readErr
+}
+
+// newScanner returns a scanner over seg that keeps seg.offset and seg.line up
+// to date with the end of the last token returned, and handles records longer
+// than MaxRecordSize according to OversizePolicy.
+func (lt *Logtailer) newScanner(t *tail, seg *segment, stats *Stats) *bufio.Scanner {
+	scanner := bufio.NewScanner(seg)
+	scanner.Buffer(nil, lt.MaxRecordSize)
+	split := bufio.ScanLines
+	// if the profile supplies a custom splitting function, use it
+	if splitter, ok := lt.Profile.(Splitter); ok {
+		split = splitter.Split
+	}
+	split = (&oversizeSplit{lt: lt, t: t, seg: seg, stats: stats, split: split, max: lt.MaxRecordSize}).Split
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		// a followed file that is stopped mid-line must not emit the partial line
+		if atEOF && seg.stopped {
+			return 0, nil, nil
+		}
+		advance, token, err := split(data, atEOF)
+		if token != nil {
+			seg.tokenOffset, seg.tokenLine = seg.offset, seg.line+1
+		}
+		seg.offset += int64(advance)
+		if advance > 0 {
+			stats.Lock()
+			stats.Bytes += int64(advance)
+			stats.Unlock()
+		}
+		seg.line += int64(bytes.Count(data[:advance], []byte{'\n'}))
+		return advance, token, err
+	})
+	return scanner
+}
+
+// saveCheckpoint persists the position reached in each log file so the next
+// run resumes after it. Nothing is saved for stdin or during a dry run.
+func (lt *Logtailer) saveCheckpoint() error {
 	if lt.DryRun {
-		args = append(args, "-t")
+		return nil
+	}
+	lt.tailsMu.Lock()
+	defer lt.tailsMu.Unlock()
+	var firstErr error
+	for _, t := range lt.tails {
+		if err := t.save(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	lt.Logger.Println("executing ", logtailBinary, args)
-	lt.logtailCmd = exec.Command(logtailBinary, args...)
+	return firstErr
 }
@@ -12,11 +12,13 @@ import (
 func ExampleNewLogtailer() {
 	tmpFile, _ := ioutil.TempFile("", "")
 	defer os.Remove(tmpFile.Name())
+	stateDir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(stateDir)
 
 	logger := log.New(os.Stderr, "logtailer", log.LstdFlags)
-	tailer := NewLogtailer(&dummy.DummyProfile{}, tmpFile.Name(), "/tmp/", logger)
+	tailer := NewLogtailer(&dummy.DummyProfile{}, tmpFile.Name(), stateDir, logger)
 	stats, _ := tailer.Run(1)
 	fmt.Println(stats)
 	// output:
-	// {"Records":0,"ParseErrors":0,"SendErrors":0}
+	// {"Records":0,"ParseErrors":0,"SendErrors":0,"Oversized":0,"Bytes":0}
 }
@@ -0,0 +1,261 @@
+package logtailer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+)
+
+// latencyBuckets are the upper bounds in seconds of the buckets of the
+// processing latency histograms.
+var latencyBuckets = []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1}
+
+// A histogram counts durations into latencyBuckets.
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range latencyBuckets {
+		if s <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += s
+}
+
+// metricFamily is a metric along with its samples.
+type metricFamily struct {
+	name, typ, help string
+	samples         []string
+}
+
+// A metricSet gathers samples and writes them in the Prometheus text
+// exposition format, grouped by metric.
+type metricSet struct {
+	families []*metricFamily
+	byName   map[string]*metricFamily
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{byName: make(map[string]*metricFamily)}
+}
+
+// add adds a sample of the metric name. labels are pairs of label names and
+// values.
+func (m *metricSet) add(name, typ, help string, labels []string, value float64) {
+	m.addSample(name, typ, help, name, labels, value)
+}
+
+// addSample adds a sample called sample, which differs from name for the
+// parts of a histogram.
+func (m *metricSet) addSample(name, typ, help, sample string, labels []string, value float64) {
+	f, ok := m.byName[name]
+	if !ok {
+		f = &metricFamily{name: name, typ: typ, help: help}
+		m.byName[name] = f
+		m.families = append(m.families, f)
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	if len(pairs) > 0 {
+		sample += "{" + strings.Join(pairs, ",") + "}"
+	}
+	f.samples = append(f.samples, sample+" "+formatValue(value))
+}
+
+// addHistogram adds the buckets, sum and count of h.
+func (m *metricSet) addHistogram(name, help string, labels []string, h *histogram) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range latencyBuckets {
+		m.addSample(name, "histogram", help, name+"_bucket", withLabel(labels, "le", formatValue(bound)), float64(h.counts[i]))
+	}
+	m.addSample(name, "histogram", help, name+"_bucket", withLabel(labels, "le", "+Inf"), float64(h.count))
+	m.addSample(name, "histogram", help, name+"_sum", labels, h.sum)
+	m.addSample(name, "histogram", help, name+"_count", labels, float64(h.count))
+}
+
+func (m *metricSet) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for _, f := range m.families {
+		n, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s\n", f.name, f.help, f.name, f.typ, strings.Join(f.samples, "\n"))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// withLabel returns labels with the label name set to value.
+func withLabel(labels []string, name, value string) []string {
+	return append(append([]string(nil), labels...), name, value)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricName turns s into a valid metric name.
+func metricName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// addStats adds the counters in stats.
+func (m *metricSet) addStats(labels []string, stats *Stats) {
+	stats.Lock()
+	defer stats.Unlock()
+	m.add("logtailer_records_read_total", "counter", "Records read from the input.", labels, float64(stats.Records))
+	m.add("logtailer_bytes_read_total", "counter", "Bytes read from the input.", labels, float64(stats.Bytes))
+	m.add("logtailer_parse_errors_total", "counter", "Records the profile failed to parse.", labels, float64(stats.ParseErrors))
+	m.add("logtailer_send_errors_total", "counter", "Errors sending output.", labels, float64(stats.SendErrors))
+	m.add("logtailer_oversized_records_total", "counter", "Records longer than the maximum record size.", labels, float64(stats.Oversized))
+}
+
+// gather adds the metrics of the current run, other than its stats, to m.
+func (lt *Logtailer) gather(m *metricSet, labels []string) {
+	lt.runningMu.Lock()
+	running, ledger, window, latency := lt.running != nil, lt.ledger, lt.window, lt.latency
+	lt.runningMu.Unlock()
+	if !running {
+		return
+	}
+
+	inFlight, awaitingAck := ledger.pending()
+	m.add("logtailer_queue_depth", "gauge", "Records in each stage of the pipeline.", withLabel(labels, "queue", "in_flight"), float64(inFlight))
+	m.add("logtailer_queue_depth", "gauge", "Records in each stage of the pipeline.", withLabel(labels, "queue", "awaiting_ack"), float64(awaitingAck))
+	if lt.Ordered {
+		m.add("logtailer_queue_depth", "gauge", "Records in each stage of the pipeline.", withLabel(labels, "queue", "reorder_window"), float64(len(window)))
+	}
+
+	for i, h := range latency {
+		m.addHistogram("logtailer_process_seconds", "Time the profile takes to process a record, by worker.", withLabel(labels, "worker", strconv.Itoa(i)), h)
+	}
+
+	lt.tailsMu.Lock()
+	var tails []*tail
+	for _, t := range lt.tails {
+		tails = append(tails, t)
+	}
+	lt.tailsMu.Unlock()
+	sort.Slice(tails, func(i, j int) bool { return tails[i].path < tails[j].path })
+	for _, t := range tails {
+		if lag, ok := t.lag(); ok {
+			m.add("logtailer_checkpoint_lag_bytes", "gauge", "Bytes in the log file past the checkpoint.", withLabel(labels, "file", t.path), float64(lag))
+		}
+	}
+
+	if p, ok := lt.Profile.(profiles.MetricsProfile); ok {
+		counters := p.Counters()
+		names := make([]string, 0, len(counters))
+		for name := range counters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			metric := "logtailer_profile_" + metricName(name)
+			m.add(metric, "counter", "Counted by the profile.", labels, counters[name])
+		}
+	}
+}
+
+// lag returns how many bytes of the file at t's path are past its checkpoint,
+// or false if there is no checkpoint yet. The whole file counts when the
+// checkpoint is in a file rotated away since.
+func (t *tail) lag() (int64, bool) {
+	t.positionMu.Lock()
+	position := t.position
+	t.positionMu.Unlock()
+	if position == nil {
+		return 0, false
+	}
+	fi, err := os.Stat(t.path)
+	if err != nil {
+		return 0, false
+	}
+	if inode(fi) != position.Inode || fi.Size() < position.Offset {
+		return fi.Size(), true
+	}
+	return fi.Size() - position.Offset, true
+}
+
+// MetricsHandler serves the metrics of the tailer in the Prometheus text
+// format: the stats of the current run, how many records are in each stage of
+// the pipeline, how long each worker takes to process records, how far the
+// checkpoint of each file is behind its end, and the counters of profiles that
+// implement profiles.MetricsProfile.
+func (lt *Logtailer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		labels := []string{"profile", lt.Profile.Name()}
+		m := newMetricSet()
+		lt.runningMu.Lock()
+		stats := lt.stats
+		lt.runningMu.Unlock()
+		if stats != nil {
+			m.addStats(labels, stats)
+		}
+		lt.gather(m, labels)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WriteTo(w)
+	})
+}
+
+// MetricsHandler serves the metrics of every tailer, as Logtailer's does,
+// labelled by tailer name. The stats count all the runs of each tailer.
+func (d *Daemon) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := newMetricSet()
+		stats := d.Stats()
+		names := make([]string, 0, len(stats))
+		for name := range stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			d.mu.Lock()
+			t, ok := d.tailers[name]
+			var current *Logtailer
+			if ok {
+				current = t.current
+			}
+			d.mu.Unlock()
+			if !ok {
+				continue
+			}
+			labels := []string{"tailer", name, "profile", t.config.Profile}
+			m.addStats(labels, stats[name])
+			if current != nil {
+				current.gather(m, labels)
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WriteTo(w)
+	})
+}
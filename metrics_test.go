@@ -0,0 +1,106 @@
+package logtailer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+)
+
+func TestMetricSet(t *testing.T) {
+	m := newMetricSet()
+	m.add("a_total", "counter", "Some a.", []string{"x", `"1"`}, 1)
+	m.add("b", "gauge", "Some b.", nil, 0.5)
+	m.add("a_total", "counter", "Some a.", []string{"x", "2"}, 2)
+	h := newHistogram()
+	h.observe(time.Millisecond)
+	h.observe(time.Minute)
+	m.addHistogram("c_seconds", "Some c.", []string{"w", "0"}, h)
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	ensure.Nil(t, err)
+	lines := strings.Split(buf.String(), "\n")
+	ensure.DeepEqual(t, lines[:8], []string{
+		"# HELP a_total Some a.",
+		"# TYPE a_total counter",
+		`a_total{x="\"1\""} 1`,
+		`a_total{x="2"} 2`,
+		"# HELP b Some b.",
+		"# TYPE b gauge",
+		"b 0.5",
+		"# HELP c_seconds Some c.",
+	})
+	ensure.StringContains(t, buf.String(), `c_seconds_bucket{w="0",le="0.0005"} 0`)
+	ensure.StringContains(t, buf.String(), `c_seconds_bucket{w="0",le="0.001"} 1`)
+	ensure.StringContains(t, buf.String(), `c_seconds_bucket{w="0",le="+Inf"} 2`)
+	ensure.StringContains(t, buf.String(), `c_seconds_sum{w="0"} 60.001`)
+	ensure.StringContains(t, buf.String(), `c_seconds_count{w="0"} 2`)
+}
+
+// countingProfile is a collectProfile with a counter of its own.
+type countingProfile struct {
+	collectProfile
+}
+
+func (p *countingProfile) Counters() map[string]float64 {
+	p.Lock()
+	defer p.Unlock()
+	return map[string]float64{"lines_total": float64(len(p.lines))}
+}
+
+func scrape(lt *Logtailer) string {
+	w := httptest.NewRecorder()
+	lt.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestLogtailerMetrics(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Millisecond
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "1", "22")
+
+	p := &countingProfile{}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Follow = true
+	done := make(chan error)
+	go func() {
+		_, err := lt.Run(1)
+		done <- err
+	}()
+	ensure.DeepEqual(t, len(waitForLines(&p.collectProfile, 2)), 2)
+
+	// wait for the output to catch up
+	var metrics string
+	lag := `logtailer_checkpoint_lag_bytes{profile="collect",file="` + logFile + `"} 0`
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if metrics = scrape(lt); strings.Contains(metrics, lag) {
+			break
+		}
+	}
+	for _, line := range []string{
+		`logtailer_records_read_total{profile="collect"} 2`,
+		`logtailer_bytes_read_total{profile="collect"} 5`,
+		`logtailer_parse_errors_total{profile="collect"} 0`,
+		`logtailer_queue_depth{profile="collect",queue="in_flight"} 0`,
+		`logtailer_process_seconds_count{profile="collect",worker="0"} 2`,
+		lag,
+		`logtailer_profile_lines_total{profile="collect"} 2`,
+	} {
+		ensure.StringContains(t, metrics, line)
+	}
+
+	lt.Stop()
+	ensure.Nil(t, <-done)
+}
@@ -0,0 +1,113 @@
+package logtailer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultMaxRecordSize is the default for Logtailer.MaxRecordSize.
+const DefaultMaxRecordSize = 1024 * 1024
+
+// OversizePolicy decides what happens to records larger than MaxRecordSize.
+type OversizePolicy string
+
+const (
+	// TruncateOversize hands the first MaxRecordSize bytes of the record to the
+	// profile, marked as truncated.
+	TruncateOversize OversizePolicy = "truncate"
+	// SkipOversize drops the record.
+	SkipOversize OversizePolicy = "skip"
+	// SpillOversize drops the record after appending it in full to a spill
+	// file next to the checkpoint of the file it was read from.
+	SpillOversize OversizePolicy = "spill"
+)
+
+// ParseOversizePolicy checks that s names an OversizePolicy.
+func ParseOversizePolicy(s string) (OversizePolicy, error) {
+	switch p := OversizePolicy(s); p {
+	case TruncateOversize, SkipOversize, SpillOversize:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown oversize policy %q", s)
+}
+
+// oversizeSplit wraps a split function so that records which do not fit in
+// the scanner's buffer are handled according to the policy instead of ending
+// the scan with bufio.ErrTooLong.
+type oversizeSplit struct {
+	lt    *Logtailer
+	t     *tail
+	seg   *segment
+	stats *Stats
+	split bufio.SplitFunc
+	max   int
+
+	// discarding is set while the rest of an oversized record is dropped.
+	discarding bool
+}
+
+func (o *oversizeSplit) Split(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := o.split(data, atEOF)
+	if o.discarding {
+		switch {
+		case err != nil:
+			return advance, token, err
+		case token != nil || atEOF:
+			// this is the end of the oversized record
+			o.spillWrite(token)
+			o.spillWrite([]byte{'\n'})
+			o.discarding = false
+			return advance, nil, nil
+		case advance > 0:
+			o.spillWrite(data[:advance])
+			return advance, nil, nil
+		case len(data) < o.max:
+			return 0, nil, nil
+		}
+		o.spillWrite(data)
+		return len(data), nil, nil
+	}
+
+	if token != nil || advance > 0 || err != nil || atEOF || len(data) < o.max {
+		return advance, token, err
+	}
+
+	// the buffer is full and holds no complete record
+	o.stats.Lock()
+	o.stats.Oversized++
+	o.stats.Unlock()
+	o.lt.Logger.Printf("record at %s:%d is longer than %d bytes, policy %s", o.t.path, o.seg.line+1, o.max, o.lt.OversizePolicy)
+	o.discarding = true
+	switch o.lt.OversizePolicy {
+	case SkipOversize:
+		return len(data), nil, nil
+	case SpillOversize:
+		o.spillWrite(data)
+		return len(data), nil, nil
+	default:
+		o.seg.truncated = true
+		return len(data), data, nil
+	}
+}
+
+// spillWrite appends data to the spill file of the tail, opening it first if
+// needed. Nothing is written during a dry run.
+func (o *oversizeSplit) spillWrite(data []byte) {
+	if o.lt.OversizePolicy != SpillOversize || o.lt.DryRun {
+		return
+	}
+	if o.t.spill == nil {
+		path := strings.TrimSuffix(o.t.stateFile, ".state") + ".oversize"
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			o.lt.Logger.Println("error opening spill file:", err)
+			return
+		}
+		o.t.spill = f
+	}
+	if _, err := o.t.spill.Write(data); err != nil {
+		o.lt.Logger.Println("error writing spill file:", err)
+	}
+}
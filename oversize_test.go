@@ -0,0 +1,85 @@
+package logtailer
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/facebookgo/ensure"
+)
+
+func tailOversize(t *testing.T, policy OversizePolicy) (*recordProfile, *Stats, string) {
+	dir := tempDir(t)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "one", strings.Repeat("x", 100), "two", strings.Repeat("y", 40), "three")
+
+	p := &recordProfile{}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.MaxRecordSize = 16
+	lt.OversizePolicy = policy
+	stats, err := lt.Run(1)
+	ensure.Nil(t, err)
+	return p, stats, dir
+}
+
+func texts(records []profiles.Record) []string {
+	var texts []string
+	for _, r := range records {
+		texts = append(texts, r.Text)
+	}
+	return texts
+}
+
+func TestOversizeTruncate(t *testing.T) {
+	p, stats, dir := tailOversize(t, TruncateOversize)
+	defer os.RemoveAll(dir)
+	ensure.DeepEqual(t, stats.Oversized, 2)
+	ensure.DeepEqual(t, len(p.records), 5)
+	ensure.DeepEqual(t, p.records[1].Text, strings.Repeat("x", 16))
+	ensure.True(t, p.records[1].Truncated)
+	ensure.DeepEqual(t, p.records[2].Text, "two")
+	ensure.False(t, p.records[2].Truncated)
+	ensure.DeepEqual(t, p.records[2].Line, int64(3))
+	ensure.DeepEqual(t, p.records[4].Text, "three")
+}
+
+func TestOversizeSkip(t *testing.T) {
+	p, stats, dir := tailOversize(t, SkipOversize)
+	defer os.RemoveAll(dir)
+	ensure.DeepEqual(t, stats.Oversized, 2)
+	ensure.DeepEqual(t, texts(p.records), []string{"one", "two", "three"})
+
+	// the checkpoint covers the skipped records
+	appendLines(t, filepath.Join(dir, "app.log"), "four")
+	ensure.DeepEqual(t, tailOnce(t, filepath.Join(dir, "app.log"), dir), []string{"four"})
+}
+
+func TestOversizeSpill(t *testing.T) {
+	p, _, dir := tailOversize(t, SpillOversize)
+	defer os.RemoveAll(dir)
+	ensure.DeepEqual(t, texts(p.records), []string{"one", "two", "three"})
+
+	spills, err := filepath.Glob(filepath.Join(dir, "*.oversize"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(spills), 1)
+	spilled, err := ioutil.ReadFile(spills[0])
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(spilled), strings.Repeat("x", 100)+"\n"+strings.Repeat("y", 40)+"\n")
+}
+
+func TestBadMaxRecordSize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	appendLines(t, logFile, "one")
+
+	lt := NewLogtailerFiles(&collectProfile{}, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.MaxRecordSize = 0
+	_, err := lt.Run(1)
+	ensure.Err(t, err, regexp.MustCompile("max record size must be at least 1"))
+}
@@ -4,10 +4,23 @@
 // This profile does not modify input lines and simply prints them to stdout.
 package dummy
 
-import "fmt"
+import "github.com/ParsePlatform/logtailer/profiles"
+
+func init() {
+	profiles.Register("dummy", New)
+}
+
+// New creates a DummyProfile. It takes no options.
+func New(options profiles.Options) (profiles.Profile, error) {
+	return new(DummyProfile), nil
+}
 
 // DummyProfile provides a stripped down example of how to write a logtailer profile.
-type DummyProfile struct{}
+type DummyProfile struct {
+	// StdoutOutput prints the output to stdout. Profiles that deliver their
+	// output elsewhere implement HandleOutput themselves.
+	profiles.StdoutOutput
+}
 
 // Init does nothing in the dummy profile, but is here to satisfy the interface
 func (p *DummyProfile) Init() error {
@@ -25,34 +38,3 @@ func (p *DummyProfile) Name() string {
 func (p *DummyProfile) ProcessRecord(record string) (interface{}, error) {
 	return []byte(record), nil
 }
-
-// HandleOutput recieves a channel of input lines and a flag of whether or not
-// this is a dry run being invoked (to avoid side-effects).
-//
-// The return value is a channel of errors. parse.com/logtailer keeps track of
-// the number of errors and exits non-zero if they are over a threshold.
-func (p *DummyProfile) HandleOutput(records <-chan interface{}, dryRun bool) <-chan error {
-
-	// Set up the error channel.
-	errChan := make(chan error)
-
-	// Launch the consumption goroutine.
-	go func() {
-
-		// Close errChan when this goroutine finishes to signal being done.
-		defer close(errChan)
-
-		// Consume lines input channel until it is closed (if consuming stdin),
-		// this is potentially never.
-		for record := range records {
-			line, ok := record.([]byte)
-			if !ok {
-				errChan <- fmt.Errorf("Unexpected output record type: %t", record)
-				continue
-			}
-			fmt.Println(string(line))
-		}
-	}()
-
-	return errChan
-}
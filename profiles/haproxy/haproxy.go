@@ -0,0 +1,133 @@
+// Package haproxy implements a logtailer profile that parses HAProxy's HTTP
+// and TCP logs, as written with "option httplog" and "option tcplog", into
+// JSON.
+//
+// Fields are named as in the HAProxy documentation. The timers (Tq, Tw, Tc,
+// Tr and Tt), the byte count, the connection counters and the queue lengths
+// are integers, with -1 meaning the step was not reached. The accept date
+// becomes the time field, and the first two characters of the termination
+// state are also given as termination_cause and session_state unless they are
+// "-". Captured headers become lists, and the HTTP request line is split into
+// method, uri and version. The syslog header in front of the line, if any,
+// gives the hostname and pid.
+package haproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+)
+
+func init() {
+	profiles.Register("haproxy", New)
+}
+
+// New creates a HAProxyProfile. It takes no options.
+func New(options profiles.Options) (profiles.Profile, error) {
+	return &HAProxyProfile{}, nil
+}
+
+// HAProxyProfile is a logtailer profile that parses HAProxy HTTP and TCP logs.
+type HAProxyProfile struct {
+	profiles.StdoutOutput
+}
+
+const (
+	syslogHeader = `^(?:\w{3} [ \d]\d \d{2}:\d{2}:\d{2} (?P<hostname>\S+) [\w.-]+\[(?P<pid>\d+)\]: )?`
+	connection   = `(?P<client_ip>\S+):(?P<client_port>\d+) \[(?P<accept_date>[^\]]+)\] (?P<frontend_name>\S+) (?P<backend_name>[^/ ]+)/(?P<server_name>\S+) `
+	counters     = `(?P<actconn>\d+)/(?P<feconn>\d+)/(?P<beconn>\d+)/(?P<srv_conn>\d+)/\+?(?P<retries>\d+) (?P<srv_queue>\d+)/(?P<backend_queue>\d+)`
+)
+
+var (
+	// httpLogRe matches a line of the HTTP log format:
+	//	10.0.1.2:33317 [06/Feb/2009:12:14:14.655] http-in static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 {1wt.eu} {} "GET /index.html HTTP/1.1"
+	httpLogRe = regexp.MustCompile(syslogHeader + connection +
+		`(?P<Tq>-?\d+)/(?P<Tw>-?\d+)/(?P<Tc>-?\d+)/(?P<Tr>-?\d+)/\+?(?P<Tt>-?\d+) ` +
+		`(?P<status_code>-?\d+) \+?(?P<bytes_read>\d+) (?P<captured_request_cookie>\S+) (?P<captured_response_cookie>\S+) (?P<termination_state>\S{4}) ` +
+		counters + `(?: \{(?P<captured_request_headers>[^}]*)\})?(?: \{(?P<captured_response_headers>[^}]*)\})? "(?P<http_request>.*)"$`)
+	// tcpLogRe matches a line of the TCP log format:
+	//	10.0.1.2:33313 [06/Feb/2009:12:12:51.443] fnt bck/srv1 0/0/5007 212 -- 0/0/0/0/3 0/0
+	tcpLogRe = regexp.MustCompile(syslogHeader + connection +
+		`(?P<Tw>-?\d+)/(?P<Tc>-?\d+)/\+?(?P<Tt>-?\d+) \+?(?P<bytes_read>\d+) (?P<termination_state>\S{2}) ` +
+		counters + `$`)
+)
+
+// intFields are the fields typed as integers.
+var intFields = map[string]bool{
+	"pid": true, "client_port": true, "Tq": true, "Tw": true, "Tc": true, "Tr": true, "Tt": true,
+	"status_code": true, "bytes_read": true, "actconn": true, "feconn": true, "beconn": true,
+	"srv_conn": true, "retries": true, "srv_queue": true, "backend_queue": true,
+}
+
+// Name returns the name of the profile and must be unique amongst registered.
+// profiles
+func (p *HAProxyProfile) Name() string {
+	return "haproxy"
+}
+
+// Init does nothing.
+func (p *HAProxyProfile) Init() error {
+	return nil
+}
+
+// ProcessRecord is invoked for every input log line. It returns the line as
+// JSON, or an error if it is in neither the HTTP nor the TCP log format.
+func (p *HAProxyProfile) ProcessRecord(line string) (interface{}, error) {
+	logType, re := "http", httpLogRe
+	match := re.FindStringSubmatch(line)
+	if match == nil {
+		logType, re = "tcp", tcpLogRe
+		if match = re.FindStringSubmatch(line); match == nil {
+			return nil, fmt.Errorf("logtailer.haproxy: line is not an HTTP or TCP log: %s", line)
+		}
+	}
+
+	fields := map[string]interface{}{"log_type": logType}
+	for i, name := range re.SubexpNames() {
+		value := match[i]
+		if name == "" || value == "" || value == "-" {
+			continue
+		}
+		switch {
+		case intFields[name]:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("logtailer.haproxy: bad %s %q", name, value)
+			}
+			fields[name] = n
+		case name == "accept_date":
+			t, err := time.ParseInLocation("02/Jan/2006:15:04:05.000", value, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("logtailer.haproxy: bad accept_date %q", value)
+			}
+			fields["time"] = t
+		case name == "termination_state":
+			fields[name] = value
+			if value[0] != '-' {
+				fields["termination_cause"] = value[:1]
+			}
+			if value[1] != '-' {
+				fields["session_state"] = value[1:2]
+			}
+		case strings.HasPrefix(name, "captured_") && strings.HasSuffix(name, "_headers"):
+			fields[name] = strings.Split(value, "|")
+		case name == "http_request":
+			fields[name] = value
+			if parts := strings.SplitN(value, " ", 3); len(parts) >= 2 {
+				fields["method"] = parts[0]
+				fields["uri"] = parts[1]
+				if len(parts) == 3 {
+					fields["version"] = parts[2]
+				}
+			}
+		default:
+			fields[name] = value
+		}
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,104 @@
+package haproxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+)
+
+// process returns the fields ProcessRecord parses out of line, without the
+// time, which depends on the local time zone.
+func process(t *testing.T, line string) map[string]interface{} {
+	p, err := New(nil)
+	ensure.Nil(t, err)
+	ensure.Nil(t, p.Init())
+	out, err := p.ProcessRecord(line)
+	ensure.Nil(t, err)
+	var fields map[string]interface{}
+	ensure.Nil(t, json.Unmarshal(out.([]byte), &fields))
+	ensure.NotNil(t, fields["time"])
+	delete(fields, "time")
+	return fields
+}
+
+func TestHTTPLog(t *testing.T) {
+	fields := process(t, `Feb  6 12:14:14 lb1 haproxy[14389]: 10.0.1.2:33317 [06/Feb/2009:12:14:14.655] http-in static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 {1wt.eu|curl/7.47.0} {} "GET /index.html HTTP/1.1"`)
+	ensure.DeepEqual(t, fields, map[string]interface{}{
+		"log_type":                 "http",
+		"hostname":                 "lb1",
+		"pid":                      float64(14389),
+		"client_ip":                "10.0.1.2",
+		"client_port":              float64(33317),
+		"frontend_name":            "http-in",
+		"backend_name":             "static",
+		"server_name":              "srv1",
+		"Tq":                       float64(10),
+		"Tw":                       float64(0),
+		"Tc":                       float64(30),
+		"Tr":                       float64(69),
+		"Tt":                       float64(109),
+		"status_code":              float64(200),
+		"bytes_read":               float64(2750),
+		"termination_state":        "----",
+		"actconn":                  float64(1),
+		"feconn":                   float64(1),
+		"beconn":                   float64(1),
+		"srv_conn":                 float64(1),
+		"retries":                  float64(0),
+		"srv_queue":                float64(0),
+		"backend_queue":            float64(0),
+		"captured_request_headers": []interface{}{"1wt.eu", "curl/7.47.0"},
+		"http_request":             "GET /index.html HTTP/1.1",
+		"method":                   "GET",
+		"uri":                      "/index.html",
+		"version":                  "HTTP/1.1",
+	})
+}
+
+func TestHTTPLogQueued(t *testing.T) {
+	fields := process(t, `10.0.1.2:33318 [06/Feb/2009:12:14:14.655] http-in~ api/<NOSRV> 5/-1/-1/-1/+10005 503 +212 - - sQ-- 8/8/5/0/+3 0/17 "POST /1/classes HTTP/1.1"`)
+	ensure.DeepEqual(t, fields["frontend_name"], "http-in~")
+	ensure.DeepEqual(t, fields["server_name"], "<NOSRV>")
+	ensure.DeepEqual(t, fields["Tw"], float64(-1))
+	ensure.DeepEqual(t, fields["Tt"], float64(10005))
+	ensure.DeepEqual(t, fields["bytes_read"], float64(212))
+	ensure.DeepEqual(t, fields["termination_cause"], "s")
+	ensure.DeepEqual(t, fields["session_state"], "Q")
+	ensure.DeepEqual(t, fields["retries"], float64(3))
+	ensure.DeepEqual(t, fields["backend_queue"], float64(17))
+	ensure.DeepEqual(t, fields["method"], "POST")
+	_, ok := fields["captured_request_headers"]
+	ensure.False(t, ok)
+}
+
+func TestTCPLog(t *testing.T) {
+	fields := process(t, `10.0.1.2:33313 [06/Feb/2009:12:12:51.443] fnt bck/srv1 0/0/5007 212 -- 0/0/0/0/3 0/0`)
+	ensure.DeepEqual(t, fields, map[string]interface{}{
+		"log_type":          "tcp",
+		"client_ip":         "10.0.1.2",
+		"client_port":       float64(33313),
+		"frontend_name":     "fnt",
+		"backend_name":      "bck",
+		"server_name":       "srv1",
+		"Tw":                float64(0),
+		"Tc":                float64(0),
+		"Tt":                float64(5007),
+		"bytes_read":        float64(212),
+		"termination_state": "--",
+		"actconn":           float64(0),
+		"feconn":            float64(0),
+		"beconn":            float64(0),
+		"srv_conn":          float64(0),
+		"retries":           float64(3),
+		"srv_queue":         float64(0),
+		"backend_queue":     float64(0),
+	})
+}
+
+func TestBadLine(t *testing.T) {
+	p, err := New(nil)
+	ensure.Nil(t, err)
+	_, err = p.ProcessRecord("Proxy http-in started.")
+	ensure.NotNil(t, err)
+}
@@ -0,0 +1,162 @@
+package helpers
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// valueListRe matches a parenthesized list of values, once they have
+	// been replaced by ?.
+	valueListRe = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	// repeatedListRe matches repeated value lists, as in a multi-row insert.
+	repeatedListRe = regexp.MustCompile(`\(\?\+\)(?:\s*,\s*\(\?\+\))+`)
+)
+
+// A SQLDialect describes how the SQL of a database quotes, escapes and
+// comments, for ScrubSQL.
+type SQLDialect struct {
+	// StringQuotes are the characters that quote strings, and
+	// IdentifierQuotes those that quote identifiers.
+	StringQuotes     string
+	IdentifierQuotes string
+	// BackslashEscapes is set if backslashes escape characters in strings.
+	BackslashEscapes bool
+	// HashComments is set if # starts a comment, and DashCommentSpace if --
+	// only does when followed by whitespace.
+	HashComments     bool
+	DashCommentSpace bool
+	// Literal, if set, recognizes the literals particular to the dialect. It
+	// is called at the start of each word, and returns the index of the last
+	// character of the literal starting at sql[i], or -1 if there is none.
+	Literal func(sql string, i int) int
+}
+
+// ScrubSQL normalizes a SQL statement so that statements differing only in
+// their literals are the same: comments are removed, strings and numbers are
+// replaced by ?, lists of values like those of IN and VALUES become (?+),
+// whitespace is collapsed and everything outside quoted identifiers is lower
+// cased.
+func ScrubSQL(sql string, dialect *SQLDialect) string {
+	var b strings.Builder
+	// identifier is set while the last character written is part of a word,
+	// so that digits in names like t1 are kept
+	identifier := false
+	space := false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		end := -1
+		if !identifier && dialect.Literal != nil {
+			end = dialect.Literal(sql, i)
+		}
+		switch {
+		case end >= 0:
+			i = end
+			c = '?'
+		case strings.IndexByte(dialect.StringQuotes, c) >= 0:
+			i = SkipSQLString(sql, i, dialect.BackslashEscapes)
+			c = '?'
+		case strings.IndexByte(dialect.IdentifierQuotes, c) >= 0:
+			end := len(sql) - 1
+			if j := strings.IndexByte(sql[i+1:], c); j >= 0 {
+				end = i + 1 + j
+			}
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteString(sql[i : end+1])
+			i = end
+			identifier = true
+			continue
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql) - i - 2
+			}
+			i += end + 3
+			space, identifier = true, false
+			continue
+		case dialect.HashComments && c == '#' || isDashComment(sql[i:], dialect.DashCommentSpace):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			space, identifier = true, false
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space, identifier = true, false
+			continue
+		case !identifier && c >= '0' && c <= '9':
+			i = skipNumber(sql, i)
+			c = '?'
+		default:
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteByte(c)
+		identifier = c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+	}
+
+	scrubbed := valueListRe.ReplaceAllString(b.String(), "(?+)")
+	scrubbed = repeatedListRe.ReplaceAllString(scrubbed, "(?+)")
+	return strings.TrimSpace(strings.TrimSuffix(scrubbed, ";"))
+}
+
+// SkipSQLString returns the index of the quote that closes the string starting
+// at sql[i], allowing for doubled quotes, and for backslash escapes if
+// backslashEscapes is set.
+func SkipSQLString(sql string, i int, backslashEscapes bool) int {
+	quote := sql[i]
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(sql) - 1
+}
+
+// isDashComment reports whether s starts with a -- comment.
+func isDashComment(s string, needSpace bool) bool {
+	if !strings.HasPrefix(s, "--") {
+		return false
+	}
+	return !needSpace || len(s) == 2 || strings.IndexByte(" \t\r\n", s[2]) >= 0
+}
+
+// skipNumber returns the index of the last character of the number starting
+// at sql[i], in decimal, hexadecimal or exponent notation.
+func skipNumber(sql string, i int) int {
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0X") {
+		i += 2
+		for i < len(sql) && strings.IndexByte("0123456789abcdefABCDEF", sql[i]) >= 0 {
+			i++
+		}
+		return i - 1
+	}
+	for i < len(sql) {
+		c := sql[i]
+		switch {
+		case c >= '0' && c <= '9' || c == '.':
+		case (c == 'e' || c == 'E') && i+1 < len(sql) && (sql[i+1] >= '0' && sql[i+1] <= '9' || sql[i+1] == '-' || sql[i+1] == '+'):
+			i++
+		default:
+			return i - 1
+		}
+		i++
+	}
+	return i - 1
+}
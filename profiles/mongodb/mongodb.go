@@ -13,6 +13,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/ParsePlatform/logtailer/sinks"
 	"github.com/tmc/mongologtools/parser"
 
 	"github.com/davecgh/go-spew/spew"
@@ -30,11 +32,12 @@ var (
 			"write_conflicts", "user_key_comparison_count", "block_cache_hit_count", "block_read_count",
 			"block_read_byte", "internal_key_skipped_count", "internal_delete_skipped_count",
 			"get_from_memtable_count", "seek_on_memtable_count", "seek_child_seek_count",
+			"source_offset", "source_line",
 		},
 		"normal": {"hostname", "database", "collection", "op", "query_signature",
 			"command_type", "ns", "rs_mismatch", "plan_summary", "comment",
 			"logtailer_host", "exception", "warning", "code", "severity",
-			"component", "parser_result", "host_state",
+			"component", "parser_result", "host_state", "source_file",
 		},
 	}
 
@@ -43,27 +46,48 @@ var (
 		"block_read_time", "block_checksum_time", "block_decompress_time", "write_wal_time", "get_snapshot_time", "get_from_memtable_time", "get_post_process_time", "get_from_output_files_time", "seek_on_memtable_time", "seek_child_seek_time", "seek_min_heap_time", "seek_internal_seek_time", "find_next_user_entry_time", "write_pre_and_post_process_time", "write_memtable_time", "db_mutex_lock_nanos", "db_condition_wait_nanos",
 	}
 
-	// maps field names to field types (int, normal, etc) populated by init().
-	fieldToType map[string]string
-
 	// holds hostname of machine running logtailer instance
 	logtailerHost string
 )
 
+func init() {
+	profiles.Register("mongodb", New)
+}
+
 // MongodbProfile is the profile used to parse mongodb logs. Output is JSON
 type MongodbProfile struct {
 	Logger *log.Logger
+	// RocksDBFields enables reporting of additional rocksdb fields.
+	RocksDBFields bool
+
+	// maps field names to field types (int, normal, etc) populated by Init.
+	fieldToType map[string]string
+}
+
+// New creates a MongodbProfile. The rocksdb_fields option sets RocksDBFields,
+// which defaults to the -logtailer.enablerocksdbfields flag.
+func New(options profiles.Options) (profiles.Profile, error) {
+	rocksDBFields, err := options.Bool("rocksdb_fields", *enableAdditionalRocksDBFields)
+	if err != nil {
+		return nil, err
+	}
+	return &MongodbProfile{RocksDBFields: rocksDBFields}, nil
 }
 
 // Init performs startup steps for the MongodbProfile
 func (p *MongodbProfile) Init() error {
 	p.Logger = log.New(os.Stderr, "DEBUG: ", log.LstdFlags|log.Lshortfile)
 
-	if *enableAdditionalRocksDBFields {
-		outputSchema["int"] = append(outputSchema["int"], rocksDBFields...)
+	// copy the schema rather than extend the shared one
+	schema := make(map[string][]string, len(outputSchema))
+	for t, fields := range outputSchema {
+		schema[t] = fields
+	}
+	if p.RocksDBFields {
+		schema["int"] = append(append([]string(nil), schema["int"]...), rocksDBFields...)
 	}
 
-	fieldToType = fieldToTypeFromSchema(outputSchema)
+	p.fieldToType = fieldToTypeFromSchema(schema)
 
 	return nil
 }
@@ -95,6 +119,16 @@ func mongoTimeToUnixUTC(in string) int64 {
 // ProcessRecord is invoked for every input log line. It returns a transformed.
 // line or an error
 func (p *MongodbProfile) ProcessRecord(line string) (interface{}, error) {
+	return p.processRecord(line, nil)
+}
+
+// ProcessFullRecord is ProcessRecord that also reports which file and where in
+// it the line was read from.
+func (p *MongodbProfile) ProcessFullRecord(record *profiles.Record) (interface{}, error) {
+	return p.processRecord(record.Text, record)
+}
+
+func (p *MongodbProfile) processRecord(line string, record *profiles.Record) (interface{}, error) {
 	values, err := parser.ParseLogLine(line)
 	if err != nil {
 		return nil, err
@@ -117,16 +151,19 @@ func (p *MongodbProfile) ProcessRecord(line string) (interface{}, error) {
 	if err := p.applyTransformations(values); err != nil {
 		return nil, err
 	}
+	if record != nil {
+		values["source_file"] = record.Source
+		values["source_offset"] = record.Offset
+		values["source_line"] = record.Line
+	}
 
-	var outputRecord string
 	marshalled, err := json.Marshal(values)
 	if err != nil {
+		// nothing to output, the line is skipped
 		p.Logger.Printf("error serializing to json %s", err)
-	} else {
-		outputRecord = string(marshalled)
+		return nil, nil
 	}
-
-	return outputRecord, nil
+	return string(marshalled), nil
 }
 
 // applyTransformations takes the fields and populates more fields.
@@ -225,26 +262,24 @@ func (p *MongodbProfile) applyTransformations(match map[string]interface{}) erro
 // HandleOutput satisfies part of the profile.Profile interface, converting
 // lines to JSON and printing to stdout
 func (p *MongodbProfile) HandleOutput(records <-chan interface{}, dryRun bool) <-chan error {
-	errChan := make(chan error)
-	go func() {
-		defer close(errChan)
-		for record := range records {
-			message, ok := record.(string)
-			if !ok {
-				errChan <- fmt.Errorf("Unexpected output record type: %t", record)
-				continue
-			}
-			if dryRun {
+	return p.HandleOutputAck(records, dryRun, func(int) {})
+}
+
+// HandleOutputAck satisfies the profile.AckingProfile interface, acknowledging
+// each record once it has been printed
+func (p *MongodbProfile) HandleOutputAck(records <-chan interface{}, dryRun bool, ack func(n int)) <-chan error {
+	if dryRun {
+		logged := make(chan interface{})
+		go func() {
+			defer close(logged)
+			for record := range records {
 				p.Logger.Println("skipping due to dry run")
-				fmt.Println(message)
-				continue
+				logged <- record
 			}
-			if len(message) > 0 {
-				fmt.Println(message)
-			}
-		}
-	}()
-	return errChan
+		}()
+		records = logged
+	}
+	return sinks.Output(sinks.NewStdout(), records, ack)
 }
 
 // Recurse through interface representing JSON and set values to where appropriate
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/ParsePlatform/logtailer/profiles"
 	"github.com/facebookgo/ensure"
 	"github.com/tmc/mongologtools/parser"
 )
@@ -196,3 +197,18 @@ func Test32NscannedAlias(t *testing.T) {
 	ensure.DeepEqual(t, record.(string),
 		`{"collection":"HistoricPotential","comment":"queryhash:4dc1bff80c867af8d6a484c8d63edd9c","component":"QUERY","context":"conn43","cursorExhausted":1,"database":"appdata352","docsExamined":200,"duration_ms":"2","global_read_lock_micros":null,"global_write_lock_micros":null,"keyUpdates":0,"keysExamined":200,"locks":{"Collection":{"acquireCount":{"r":1}},"Database":{"acquireCount":{"r":1}},"Global":{"acquireCount":{"r":2}}},"logtailer_host":"test-host","nreturned":119,"ns":"appdata352.HistoricPotential","nscanned":200,"nscanned_objects":200,"ntoreturn":1000,"ntoskip":0,"numYields":0,"num_yields":0,"op":"query","parser_result":"full","planSummary":[{"IXSCAN":[{"a":1},{"_created_at":-1}]}],"plan_summary":"[{\"IXSCAN\":[{\"a\":1},{\"_created_at\":-1}]}]","query":{"$maxScan":500000,"$maxTimeMS":29000,"$orderby":{"_created_at":-1},"$query":{"_rperm":{"$in":["?"]},"a":"?","b":"?"}},"query_signature":"{\"$maxScan\":500000,\"$maxTimeMS\":29000,\"$orderby\":{\"_created_at\":-1},\"$query\":{\"_rperm\":{\"$in\":[\"?\"]},\"a\":\"?\",\"b\":\"?\"}}","read_lock_micros":null,"reslen":68247,"scan_and_order":null,"severity":"I","timestamp":"Thu Dec 17 01:01:42.311","writeConflicts":0,"write_conflicts":0,"write_lock_micros":null}`)
 }
+
+func TestInstancesHaveTheirOwnSchema(t *testing.T) {
+	t.Parallel()
+
+	rocks, err := New(profiles.Options{"rocksdb_fields": "true"})
+	ensure.Nil(t, err)
+	plain, err := New(nil)
+	ensure.Nil(t, err)
+	ensure.Nil(t, rocks.Init())
+	ensure.Nil(t, plain.Init())
+
+	ensure.DeepEqual(t, rocks.(*MongodbProfile).fieldToType["block_read_count"], "int")
+	_, ok := plain.(*MongodbProfile).fieldToType["block_read_count"]
+	ensure.False(t, ok)
+}
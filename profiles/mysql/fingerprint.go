@@ -0,0 +1,18 @@
+package mysql
+
+import "github.com/ParsePlatform/logtailer/profiles/helpers"
+
+// dialect is how MySQL quotes, escapes and comments.
+var dialect = &helpers.SQLDialect{
+	StringQuotes:     `'"`,
+	IdentifierQuotes: "`",
+	BackslashEscapes: true,
+	HashComments:     true,
+	DashCommentSpace: true,
+}
+
+// Fingerprint normalizes a SQL statement so that statements differing only in
+// their literals are the same, see helpers.ScrubSQL.
+func Fingerprint(sql string) string {
+	return helpers.ScrubSQL(sql, dialect)
+}
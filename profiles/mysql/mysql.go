@@ -0,0 +1,183 @@
+// Package mysql implements a logtailer profile that parses the MySQL slow
+// query log into JSON.
+//
+// A slow log entry spans several lines: a "# Time:" header, left out when
+// the previous entry was logged in the same second, "# User@Host:" and
+// "# Query_time:" headers, and the statement itself, preceded by "use" and
+// "SET timestamp" statements. The profile reassembles the lines of each entry
+// into a single record, and outputs the time, user, host, ip and thread_id of
+// the entry, the figures of the other header lines, like query_time,
+// lock_time, rows_sent and rows_examined, the database, the query, and its
+// fingerprint, which is the same for statements differing only in their
+// literals.
+package mysql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+)
+
+func init() {
+	profiles.Register("mysql", New)
+}
+
+// New creates a MysqlProfile. It takes no options.
+func New(options profiles.Options) (profiles.Profile, error) {
+	return &MysqlProfile{}, nil
+}
+
+// MysqlProfile is a logtailer profile that parses the MySQL slow query log.
+type MysqlProfile struct {
+	profiles.StdoutOutput
+}
+
+var (
+	// userHostRe matches the "# User@Host:" header:
+	//	# User@Host: app[app] @ web1 [10.0.0.1]  Id:    12
+	userHostRe = regexp.MustCompile(`^# User@Host: ([^\[ ]*)\[[^\]]*\] @ (\S*) \[([^\]]*)\](?:\s+Id:\s+(\d+))?`)
+	// headerFieldRe matches the figures of the other headers:
+	//	# Query_time: 2.000274  Lock_time: 0.000111 Rows_sent: 1  Rows_examined: 1
+	headerFieldRe = regexp.MustCompile(`(\w+): (\S+)`)
+	// timestampRe matches the statement logged to give the time of the entry.
+	timestampRe = regexp.MustCompile(`^SET timestamp=(\d+);$`)
+	// useRe matches the statement logged when the database changes.
+	useRe = regexp.MustCompile("^use `?([^`;]+)`?;$")
+)
+
+// Name returns the name of the profile and must be unique amongst registered.
+// profiles
+func (p *MysqlProfile) Name() string {
+	return "mysql"
+}
+
+// Init does nothing.
+func (p *MysqlProfile) Init() error {
+	return nil
+}
+
+// Split splits the slow log into entries. An entry ends where the next one
+// starts, at a "# Time:" or "# User@Host:" header after its statement, or at
+// the banner the server writes when it starts. The last entry is only
+// complete at EOF, so in follow mode it is held back until the next one
+// begins.
+func (p *MysqlProfile) Split(data []byte, atEOF bool) (int, []byte, error) {
+	afterTime := false
+	for start := 0; start < len(data); {
+		end := bytes.IndexByte(data[start:], '\n')
+		if end < 0 {
+			break
+		}
+		line := data[start : start+end]
+		if start > 0 && startsEntry(line, afterTime) {
+			return start, data[:start-1], nil
+		}
+		afterTime = bytes.HasPrefix(line, []byte("# Time:"))
+		start += end + 1
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), bytes.TrimSuffix(data, []byte{'\n'}), nil
+	}
+	return 0, nil, nil
+}
+
+// startsEntry reports whether line is the first of an entry, given whether
+// the line before it was a "# Time:" header.
+func startsEntry(line []byte, afterTime bool) bool {
+	switch {
+	case bytes.HasPrefix(line, []byte("# Time:")):
+		return true
+	case bytes.HasPrefix(line, []byte("# User@Host:")):
+		return !afterTime
+	}
+	return isBanner(line)
+}
+
+// isBanner reports whether line starts the banner the server writes at the
+// top of the slow log when it starts:
+//
+//	/usr/sbin/mysqld, Version: 5.6.28-log (MySQL Community Server (GPL)). started with:
+func isBanner(line []byte) bool {
+	return bytes.Contains(line, []byte(", Version: ")) && bytes.HasSuffix(line, []byte("started with:"))
+}
+
+// ProcessRecord is invoked for every slow log entry. It returns the entry as
+// JSON, nothing for the server's banner, or an error if the entry has no
+// statement.
+func (p *MysqlProfile) ProcessRecord(record string) (interface{}, error) {
+	lines := strings.Split(record, "\n")
+	if isBanner([]byte(lines[0])) {
+		return nil, nil
+	}
+
+	fields := make(map[string]interface{})
+	var statement []string
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "# Time:"):
+			if t, ok := parseTime(strings.TrimSpace(strings.TrimPrefix(line, "# Time:"))); ok {
+				fields["time"] = t
+			}
+		case strings.HasPrefix(line, "# User@Host:"):
+			if match := userHostRe.FindStringSubmatch(line); match != nil {
+				fields["user"] = match[1]
+				fields["host"] = match[2]
+				fields["ip"] = match[3]
+				if match[4] != "" {
+					fields["thread_id"], _ = strconv.ParseInt(match[4], 10, 64)
+				}
+			}
+		case strings.HasPrefix(line, "# ") && !strings.HasPrefix(line, "# administrator command:"):
+			for _, kv := range headerFieldRe.FindAllStringSubmatch(line, -1) {
+				fields[strings.ToLower(kv[1])] = typeValue(kv[2])
+			}
+		default:
+			if match := timestampRe.FindStringSubmatch(line); match != nil {
+				if _, ok := fields["time"]; !ok {
+					seconds, _ := strconv.ParseInt(match[1], 10, 64)
+					fields["time"] = time.Unix(seconds, 0).UTC()
+				}
+			} else if match := useRe.FindStringSubmatch(line); match != nil && len(statement) == 0 {
+				fields["database"] = match[1]
+			} else if line != "" || len(statement) > 0 {
+				statement = append(statement, line)
+			}
+		}
+	}
+
+	query := strings.TrimSpace(strings.Join(statement, "\n"))
+	if query == "" {
+		return nil, errors.New("logtailer.mysql: slow log entry without a statement")
+	}
+	fields["query"] = query
+	fields["fingerprint"] = Fingerprint(query)
+	return json.Marshal(fields)
+}
+
+// parseTime parses the time of a "# Time:" header, which is in local time
+// before MySQL 5.7 and RFC 3339 after.
+func parseTime(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+	// the hour is padded with a space rather than a zero
+	t, err := time.ParseInLocation("060102 15:04:05", strings.Join(strings.Fields(s), " "), time.Local)
+	return t, err == nil
+}
+
+// typeValue types a header figure as an integer or a float if it is one.
+func typeValue(value string) interface{} {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return value
+}
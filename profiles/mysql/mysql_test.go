@@ -0,0 +1,129 @@
+package mysql
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+)
+
+const slowLog = `/usr/sbin/mysqld, Version: 5.6.28-log (MySQL Community Server (GPL)). started with:
+Tcp port: 3306  Unix socket: /var/lib/mysql/mysql.sock
+Time                 Id Command    Argument
+# Time: 160102 15:04:05
+# User@Host: app[app] @ web1 [10.0.0.1]  Id:    12
+# Query_time: 2.000274  Lock_time: 0.000111 Rows_sent: 1  Rows_examined: 1000
+use parse;
+SET timestamp=1451747045;
+SELECT * FROM users
+WHERE id = 42 AND name = 'bob';
+# User@Host: app[app] @ web2 [10.0.0.2]  Id:    13
+# Query_time: 1.5  Lock_time: 0.0 Rows_sent: 0  Rows_examined: 10
+SET timestamp=1451747045;
+INSERT INTO t1 (a, b) VALUES (1, 'x'), (2, 'y');
+# Time: 160102 15:04:07
+# User@Host: root[root] @ localhost []  Id:     3
+# Query_time: 0.5  Lock_time: 0.0 Rows_sent: 0  Rows_examined: 0
+SET timestamp=1451747047;
+# administrator command: Ping;
+`
+
+func split(t *testing.T, log string) []string {
+	p, err := New(nil)
+	ensure.Nil(t, err)
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	scanner.Split(p.(*MysqlProfile).Split)
+	var entries []string
+	for scanner.Scan() {
+		entries = append(entries, scanner.Text())
+	}
+	ensure.Nil(t, scanner.Err())
+	return entries
+}
+
+func TestSplit(t *testing.T) {
+	entries := split(t, slowLog)
+	ensure.DeepEqual(t, len(entries), 4)
+	ensure.True(t, strings.HasPrefix(entries[0], "/usr/sbin/mysqld"))
+	ensure.True(t, strings.HasPrefix(entries[1], "# Time: 160102 15:04:05\n# User@Host: app[app] @ web1"))
+	ensure.True(t, strings.HasSuffix(entries[1], "name = 'bob';"))
+	ensure.True(t, strings.HasPrefix(entries[2], "# User@Host: app[app] @ web2"))
+	ensure.True(t, strings.HasSuffix(entries[3], "# administrator command: Ping;"))
+}
+
+func TestSplitHoldsBackLastEntry(t *testing.T) {
+	p := &MysqlProfile{}
+	entry := "# User@Host: app[app] @ web1 [10.0.0.1]\n# Query_time: 1  Lock_time: 0 Rows_sent: 0  Rows_examined: 0\nSELECT 1;\n"
+	advance, token, err := p.Split([]byte(entry), false)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, advance, 0)
+	ensure.True(t, token == nil)
+
+	advance, token, err = p.Split([]byte(entry+"# Time: 160102 15:04:05\n"), false)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, advance, len(entry))
+	ensure.DeepEqual(t, string(token), strings.TrimSuffix(entry, "\n"))
+}
+
+func TestProcessRecord(t *testing.T) {
+	p, err := New(nil)
+	ensure.Nil(t, err)
+	entries := split(t, slowLog)
+
+	out, err := p.ProcessRecord(entries[0])
+	ensure.Nil(t, err)
+	ensure.True(t, out == nil)
+
+	out, err = p.ProcessRecord(entries[2])
+	ensure.Nil(t, err)
+	var fields map[string]interface{}
+	ensure.Nil(t, json.Unmarshal(out.([]byte), &fields))
+	ensure.DeepEqual(t, fields, map[string]interface{}{
+		"time":          "2016-01-02T15:04:05Z",
+		"user":          "app",
+		"host":          "web2",
+		"ip":            "10.0.0.2",
+		"thread_id":     float64(13),
+		"query_time":    1.5,
+		"lock_time":     float64(0),
+		"rows_sent":     float64(0),
+		"rows_examined": float64(10),
+		"query":         "INSERT INTO t1 (a, b) VALUES (1, 'x'), (2, 'y');",
+		"fingerprint":   "insert into t1 (a, b) values (?+)",
+	})
+
+	out, err = p.ProcessRecord(entries[1])
+	ensure.Nil(t, err)
+	fields = nil
+	ensure.Nil(t, json.Unmarshal(out.([]byte), &fields))
+	ensure.DeepEqual(t, fields["database"], "parse")
+	ensure.DeepEqual(t, fields["query_time"], 2.000274)
+	ensure.DeepEqual(t, fields["rows_examined"], float64(1000))
+	ensure.DeepEqual(t, fields["query"], "SELECT * FROM users\nWHERE id = 42 AND name = 'bob';")
+	ensure.DeepEqual(t, fields["fingerprint"], "select * from users where id = ? and name = ?")
+
+	out, err = p.ProcessRecord(entries[3])
+	ensure.Nil(t, err)
+	fields = nil
+	ensure.Nil(t, json.Unmarshal(out.([]byte), &fields))
+	ensure.DeepEqual(t, fields["query"], "# administrator command: Ping;")
+	ensure.DeepEqual(t, fields["ip"], "")
+
+	_, err = p.ProcessRecord("# User@Host: app[app] @ web1 [10.0.0.1]\n# Query_time: 1")
+	ensure.NotNil(t, err)
+}
+
+func TestFingerprint(t *testing.T) {
+	for _, c := range []struct{ sql, fingerprint string }{
+		{"SELECT * FROM t WHERE id IN (1, 2, 3)", "select * from t where id in (?+)"},
+		{"select a from `Table1` where b = 0x1F and c = 1.5e-3", "select a from `Table1` where b = ? and c = ?"},
+		{`UPDATE t2 SET name = "it\"s", note = 'it''s' WHERE id=7;`, "update t2 set name = ?, note = ? where id=?"},
+		{"SELECT /* app:42 */ col1 FROM t -- trailing\nWHERE x = 'a'", "select col1 from t where x = ?"},
+		{"INSERT INTO t VALUES(1,2),(3,4) ,(5,6)", "insert into t values(?+)"},
+		{"SELECT 1 FROM t LIMIT 10", "select ? from t limit ?"},
+	} {
+		ensure.DeepEqual(t, Fingerprint(c.sql), c.fingerprint, c.sql)
+	}
+}
@@ -0,0 +1,188 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CombinedFormat is nginx's predefined combined log_format.
+const CombinedFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+
+// variableRe matches the variables in a log_format, $name or ${name}.
+var variableRe = regexp.MustCompile(`\$(?:([a-zA-Z0-9_]+)|\{([a-zA-Z0-9_]+)\})`)
+
+// intVariables and floatVariables are the variables given a numeric type in
+// the output. Others are strings.
+var (
+	intVariables = map[string]bool{
+		"status": true, "body_bytes_sent": true, "bytes_sent": true, "request_length": true,
+		"connection": true, "connection_requests": true, "content_length": true, "pid": true,
+		"upstream_status": true, "upstream_response_length": true, "upstream_bytes_received": true,
+		"upstream_bytes_sent": true, "remote_port": true, "server_port": true,
+	}
+	floatVariables = map[string]bool{
+		"request_time": true, "msec": true, "upstream_response_time": true,
+		"upstream_connect_time": true, "upstream_header_time": true,
+	}
+)
+
+// A format parses lines written with a log_format.
+type format struct {
+	re        *regexp.Regexp
+	variables []string
+}
+
+// compileFormat turns a log_format into a regexp with a group per variable.
+// Each variable matches up to the next character of the format, or to the
+// end of the line for the last one. Upstream variables match lists.
+func compileFormat(logFormat string) (*format, error) {
+	f := &format{}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	matches := variableRe.FindAllStringSubmatchIndex(logFormat, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("log_format %q has no variables", logFormat)
+	}
+	last := 0
+	for i, m := range matches {
+		pattern.WriteString(regexp.QuoteMeta(logFormat[last:m[0]]))
+		var name string
+		if m[2] >= 0 {
+			name = logFormat[m[2]:m[3]]
+		} else {
+			name = logFormat[m[4]:m[5]]
+		}
+		f.variables = append(f.variables, name)
+
+		next := ""
+		if m[1] < len(logFormat) && (i+1 == len(matches) || matches[i+1][0] > m[1]) {
+			next = logFormat[m[1] : m[1]+1]
+		}
+		switch {
+		case next != "" && strings.HasPrefix(name, "upstream_"):
+			// a list, whose separators may contain next
+			value := "[^" + regexp.QuoteMeta(next) + "]*"
+			pattern.WriteString("(" + value + "(?:(?:, | : )" + value + ")*)")
+		case next != "":
+			pattern.WriteString("([^" + regexp.QuoteMeta(next) + "]*)")
+		case m[1] == len(logFormat):
+			pattern.WriteString("(.*)")
+		default:
+			// followed directly by another variable, nothing to stop at
+			pattern.WriteString(`(\S*?)`)
+		}
+		last = m[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(logFormat[last:]))
+	pattern.WriteString("$")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf("bad log_format %q: %v", logFormat, err)
+	}
+	f.re = re
+	return f, nil
+}
+
+// parse returns the typed fields of line, leaving out those logged as "-".
+func (f *format) parse(line string) (map[string]interface{}, error) {
+	match := f.re.FindStringSubmatch(line)
+	if match == nil {
+		return nil, fmt.Errorf("logtailer.nginx: line does not match log_format: %s", line)
+	}
+	fields := make(map[string]interface{}, len(f.variables)+4)
+	for i, name := range f.variables {
+		value := match[i+1]
+		if value == "-" || value == "" {
+			continue
+		}
+		switch name {
+		case "time_local":
+			t, err := time.Parse("02/Jan/2006:15:04:05 -0700", value)
+			if err != nil {
+				return nil, fmt.Errorf("logtailer.nginx: bad time_local %q", value)
+			}
+			fields["time"] = t
+		case "time_iso8601":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("logtailer.nginx: bad time_iso8601 %q", value)
+			}
+			fields["time"] = t
+		case "request":
+			fields["request"] = value
+			splitRequest(value, fields)
+		default:
+			typed, err := typeValue(name, value)
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = typed
+		}
+	}
+	return fields, nil
+}
+
+// splitRequest adds the request_method, request_uri, uri, args and
+// server_protocol of a request line like "GET /path?a=1 HTTP/1.1".
+func splitRequest(request string, fields map[string]interface{}) {
+	parts := strings.SplitN(request, " ", 3)
+	if len(parts) < 2 {
+		return
+	}
+	fields["request_method"] = parts[0]
+	fields["request_uri"] = parts[1]
+	uri := parts[1]
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		fields["args"] = uri[i+1:]
+		uri = uri[:i]
+	}
+	fields["uri"] = uri
+	if len(parts) == 3 {
+		fields["server_protocol"] = parts[2]
+	}
+}
+
+// typeValue converts the value of the variable name to its type. The
+// upstream variables hold a value per upstream tried, separated by ", " or by
+// " : " when the request was redirected internally, and become lists.
+func typeValue(name, value string) (interface{}, error) {
+	if strings.HasPrefix(name, "upstream_") {
+		var list []interface{}
+		for _, group := range strings.Split(value, " : ") {
+			for _, v := range strings.Split(group, ", ") {
+				typed, err := typeScalar(name, v)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, typed)
+			}
+		}
+		return list, nil
+	}
+	return typeScalar(name, value)
+}
+
+func typeScalar(name, value string) (interface{}, error) {
+	if value == "-" {
+		return nil, nil
+	}
+	switch {
+	case intVariables[name]:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("logtailer.nginx: bad %s %q", name, value)
+		}
+		return i, nil
+	case floatVariables[name]:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("logtailer.nginx: bad %s %q", name, value)
+		}
+		return f, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,120 @@
+// Package nginx implements a logtailer profile that parses nginx access and
+// error logs into JSON.
+//
+// Access log lines are parsed according to a log_format, the combined format
+// by default, with each variable becoming a field named after it, typed as an
+// integer, a float or a string. The request line is split into the
+// request_method, request_uri, uri, args and server_protocol fields, and
+// time_local or time_iso8601 become the time field. Upstream variables, which
+// hold a value per upstream tried, become lists. Variables logged as "-" are
+// left out.
+//
+// Error log lines are recognized by their format and parsed into time, level,
+// pid, tid, connection, message and the client, server, request, upstream,
+// host and referrer nginx appends to the message.
+package nginx
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+)
+
+func init() {
+	profiles.Register("nginx", New)
+}
+
+// New creates an NginxProfile. The log_format option sets the format of the
+// access log, in nginx's own log_format syntax, and defaults to the combined
+// format.
+func New(options profiles.Options) (profiles.Profile, error) {
+	p := &NginxProfile{LogFormat: options.String("log_format", CombinedFormat)}
+	if p.LogFormat == "combined" {
+		p.LogFormat = CombinedFormat
+	}
+	if _, err := compileFormat(p.LogFormat); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NginxProfile is a logtailer profile that parses nginx access and error logs.
+type NginxProfile struct {
+	profiles.StdoutOutput
+
+	// LogFormat is the log_format the access log is written with.
+	LogFormat string
+
+	format *format
+}
+
+var (
+	// errorLogRe matches an error log line:
+	//	2016/01/02 15:04:05 [error] 1234#0: *56 message, client: 10.0.0.1, ...
+	errorLogRe = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}) \[(\w+)\] (\d+)#(\d+): (?:\*(\d+) )?(.*)$`)
+	// errorContextRe matches the context nginx appends to error messages.
+	errorContextRe = regexp.MustCompile(`, (client|server|request|upstream|host|referrer): ("[^"]*"|[^,]*)`)
+)
+
+// Name returns the name of the profile and must be unique amongst registered.
+// profiles
+func (p *NginxProfile) Name() string {
+	return "nginx"
+}
+
+// Init compiles the log format.
+func (p *NginxProfile) Init() error {
+	format, err := compileFormat(p.LogFormat)
+	if err != nil {
+		return err
+	}
+	p.format = format
+	return nil
+}
+
+// ProcessRecord is invoked for every input log line. It returns the line as
+// JSON, or an error if it is neither an error log line nor in the log format.
+func (p *NginxProfile) ProcessRecord(line string) (interface{}, error) {
+	var fields map[string]interface{}
+	if match := errorLogRe.FindStringSubmatch(line); match != nil {
+		fields = parseErrorLog(match)
+	} else {
+		var err error
+		if fields, err = p.format.parse(line); err != nil {
+			return nil, err
+		}
+		fields["log_type"] = "access"
+	}
+	return json.Marshal(fields)
+}
+
+// parseErrorLog returns the fields of an error log line matched by errorLogRe.
+func parseErrorLog(match []string) map[string]interface{} {
+	fields := map[string]interface{}{"log_type": "error"}
+	if t, err := time.ParseInLocation("2006/01/02 15:04:05", match[1], time.Local); err == nil {
+		fields["time"] = t
+	}
+	fields["level"] = match[2]
+	fields["pid"], _ = strconv.Atoi(match[3])
+	fields["tid"], _ = strconv.Atoi(match[4])
+	if match[5] != "" {
+		fields["connection"], _ = strconv.Atoi(match[5])
+	}
+
+	message := match[6]
+	if loc := errorContextRe.FindStringIndex(message); loc != nil {
+		for _, kv := range errorContextRe.FindAllStringSubmatch(message[loc[0]:], -1) {
+			fields[kv[1]] = strings.Trim(kv[2], `"`)
+		}
+		message = message[:loc[0]]
+	}
+	fields["message"] = message
+	if request, ok := fields["request"].(string); ok {
+		splitRequest(request, fields)
+	}
+	return fields
+}
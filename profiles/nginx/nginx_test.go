@@ -0,0 +1,87 @@
+package nginx
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/facebookgo/ensure"
+)
+
+// process runs line through a profile with options and returns its fields.
+func process(t *testing.T, options profiles.Options, line string) map[string]interface{} {
+	p, err := New(options)
+	ensure.Nil(t, err)
+	ensure.Nil(t, p.Init())
+	out, err := p.ProcessRecord(line)
+	ensure.Nil(t, err)
+	var fields map[string]interface{}
+	ensure.Nil(t, json.Unmarshal(out.([]byte), &fields))
+	return fields
+}
+
+func TestCombined(t *testing.T) {
+	fields := process(t, nil, `10.0.0.1 - alice [10/Oct/2016:13:55:36 -0700] "GET /apps/1/classes?limit=10 HTTP/1.1" 200 2326 "-" "curl/7.47.0"`)
+	ensure.DeepEqual(t, fields, map[string]interface{}{
+		"log_type":        "access",
+		"remote_addr":     "10.0.0.1",
+		"remote_user":     "alice",
+		"time":            "2016-10-10T13:55:36-07:00",
+		"request":         "GET /apps/1/classes?limit=10 HTTP/1.1",
+		"request_method":  "GET",
+		"request_uri":     "/apps/1/classes?limit=10",
+		"uri":             "/apps/1/classes",
+		"args":            "limit=10",
+		"server_protocol": "HTTP/1.1",
+		"status":          float64(200),
+		"body_bytes_sent": float64(2326),
+		"http_user_agent": "curl/7.47.0",
+	})
+}
+
+func TestCustomFormat(t *testing.T) {
+	options := profiles.Options{"log_format": `$remote_addr [$time_iso8601] "$request" $status $request_time ${upstream_response_time}s up=$upstream_status "$http_user_agent"`}
+	fields := process(t, options, `10.0.0.2 [2016-10-10T13:55:36+00:00] "POST /1/functions/hello HTTP/2.0" 502 0.251 0.100, 0.150s up=502, 200 "Parse/1.0 (iOS)"`)
+	ensure.DeepEqual(t, fields["time"], "2016-10-10T13:55:36Z")
+	ensure.DeepEqual(t, fields["request_method"], "POST")
+	ensure.DeepEqual(t, fields["uri"], "/1/functions/hello")
+	ensure.DeepEqual(t, fields["status"], float64(502))
+	ensure.DeepEqual(t, fields["request_time"], 0.251)
+	ensure.DeepEqual(t, fields["upstream_response_time"], []interface{}{0.1, 0.15})
+	ensure.DeepEqual(t, fields["upstream_status"], []interface{}{float64(502), float64(200)})
+	ensure.DeepEqual(t, fields["http_user_agent"], "Parse/1.0 (iOS)")
+}
+
+func TestErrorLog(t *testing.T) {
+	fields := process(t, nil, `2016/10/10 13:55:36 [error] 1234#0: *56 open() "/srv/www/favicon.ico" failed (2: No such file or directory), client: 10.0.0.1, server: example.com, request: "GET /favicon.ico HTTP/1.1", host: "example.com"`)
+	delete(fields, "time")
+	ensure.DeepEqual(t, fields, map[string]interface{}{
+		"log_type":        "error",
+		"level":           "error",
+		"pid":             float64(1234),
+		"tid":             float64(0),
+		"connection":      float64(56),
+		"message":         `open() "/srv/www/favicon.ico" failed (2: No such file or directory)`,
+		"client":          "10.0.0.1",
+		"server":          "example.com",
+		"request":         "GET /favicon.ico HTTP/1.1",
+		"request_method":  "GET",
+		"request_uri":     "/favicon.ico",
+		"uri":             "/favicon.ico",
+		"server_protocol": "HTTP/1.1",
+		"host":            "example.com",
+	})
+}
+
+func TestBadLines(t *testing.T) {
+	p, err := New(nil)
+	ensure.Nil(t, err)
+	ensure.Nil(t, p.Init())
+	_, err = p.ProcessRecord("not an nginx line")
+	ensure.NotNil(t, err)
+	_, err = p.ProcessRecord(`10.0.0.1 - - [10/Oct/2016:13:55:36 -0700] "GET / HTTP/1.1" OK 0 "-" "-"`)
+	ensure.NotNil(t, err)
+
+	_, err = New(profiles.Options{"log_format": "no variables"})
+	ensure.NotNil(t, err)
+}
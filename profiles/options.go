@@ -0,0 +1,59 @@
+package profiles
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Options configure a single instance of a profile. The keys each profile
+// understands are up to the profile.
+type Options map[string]string
+
+// String returns the option key, or def if it is not set.
+func (o Options) String(key, def string) string {
+	if v, ok := o[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Bool returns the option key parsed as a bool, or def if it is not set.
+func (o Options) Bool(key string, def bool) (bool, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("bad option %s: %v", key, err)
+	}
+	return b, nil
+}
+
+// Int returns the option key parsed as an int, or def if it is not set.
+func (o Options) Int(key string, def int) (int, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("bad option %s: %v", key, err)
+	}
+	return i, nil
+}
+
+// Duration returns the option key parsed as a time.Duration, or def if it is
+// not set.
+func (o Options) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def, fmt.Errorf("bad option %s: %v", key, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,275 @@
+// Package postgresql implements a logtailer profile that parses PostgreSQL
+// logs into JSON, either stderr logs written with a log_line_prefix or csvlog
+// files.
+//
+// The lines of an entry are reassembled into a single record: the lines of a
+// multi-line statement, and the DETAIL, HINT, STATEMENT, CONTEXT, QUERY and
+// LOCATION lines that follow the message. Fields are named after the columns
+// of csvlog, with log_time as time and query as statement, and the prefix
+// escapes of stderr logs give the fields of the matching columns. The duration
+// of "duration:" messages becomes duration_ms, the statement of "statement:",
+// "execute" and the like becomes statement, and the SQLSTATE written in front
+// of the message with log_error_verbosity set to verbose becomes
+// sql_state_code. Statements are given a statement_signature with their
+// literals scrubbed.
+package postgresql
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+)
+
+func init() {
+	profiles.Register("postgresql", New)
+}
+
+// New creates a PostgresqlProfile. The format option is stderr, the default,
+// or csvlog, and the log_line_prefix option sets the log_line_prefix of
+// stderr logs, which defaults to DefaultLogLinePrefix.
+func New(options profiles.Options) (profiles.Profile, error) {
+	p := &PostgresqlProfile{
+		Format:        options.String("format", "stderr"),
+		LogLinePrefix: options.String("log_line_prefix", DefaultLogLinePrefix),
+	}
+	if err := p.Init(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PostgresqlProfile is a logtailer profile that parses PostgreSQL logs.
+type PostgresqlProfile struct {
+	profiles.StdoutOutput
+
+	// Format is the format of the log, stderr or csvlog.
+	Format string
+	// LogLinePrefix is the log_line_prefix of stderr logs.
+	LogLinePrefix string
+
+	prefix *prefix
+}
+
+// csvColumns are the fields of the columns of csvlog, up to PostgreSQL 14.
+var csvColumns = []string{
+	"time", "user_name", "database_name", "process_id", "connection_from",
+	"session_id", "session_line_num", "command_tag", "session_start_time",
+	"virtual_transaction_id", "transaction_id", "error_severity",
+	"sql_state_code", "message", "detail", "hint", "internal_query",
+	"internal_query_pos", "context", "statement", "query_pos", "location",
+	"application_name", "backend_type", "leader_pid", "query_id",
+}
+
+// continuations are the fields of the severities of the lines that continue
+// an entry.
+var continuations = map[string]string{
+	"DETAIL":    "detail",
+	"HINT":      "hint",
+	"STATEMENT": "statement",
+	"CONTEXT":   "context",
+	"QUERY":     "internal_query",
+	"LOCATION":  "location",
+}
+
+// intFields are the fields typed as integers.
+var intFields = map[string]bool{
+	"process_id": true, "session_line_num": true, "transaction_id": true,
+	"internal_query_pos": true, "query_pos": true, "leader_pid": true, "query_id": true,
+}
+
+var (
+	// sqlStateRe matches the SQLSTATE in front of a verbose message.
+	sqlStateRe = regexp.MustCompile(`(?s)^([0-9A-Z]{5}): (.*)$`)
+	// durationRe matches the messages of log_duration and
+	// log_min_duration_statement:
+	//	duration: 12.345 ms  statement: SELECT 1
+	durationRe = regexp.MustCompile(`(?s)^duration: (\d+(?:\.\d+)?) ms(?:  (.*))?$`)
+	// statementRe matches the messages of log_statement, and of the steps of
+	// the extended query protocol.
+	statementRe = regexp.MustCompile(`(?s)^(?:statement|(?:parse|bind|execute(?: fetch from)?) (?:<unnamed>|\S+)(?:/\S+)?): (.*)$`)
+)
+
+// Name returns the name of the profile and must be unique amongst registered.
+// profiles
+func (p *PostgresqlProfile) Name() string {
+	return "postgresql"
+}
+
+// Init checks the format and compiles the log_line_prefix of stderr logs.
+func (p *PostgresqlProfile) Init() error {
+	switch p.Format {
+	case "stderr":
+		prefix, err := compilePrefix(p.LogLinePrefix)
+		if err != nil {
+			return err
+		}
+		p.prefix = prefix
+	case "csvlog":
+	default:
+		return fmt.Errorf("unknown postgresql log format %q", p.Format)
+	}
+	return nil
+}
+
+// Split splits the log into entries. In follow mode the last entry is held
+// back until the next one begins, as more lines may yet be added to it.
+func (p *PostgresqlProfile) Split(data []byte, atEOF bool) (int, []byte, error) {
+	if p.Format == "csvlog" {
+		return splitCSV(data, atEOF)
+	}
+	for start := 0; start < len(data); {
+		end := bytes.IndexByte(data[start:], '\n')
+		if end < 0 {
+			break
+		}
+		if start > 0 {
+			if _, severity, _, ok := p.prefix.match(string(data[start : start+end])); ok && continuations[severity] == "" {
+				return start, data[:start-1], nil
+			}
+		}
+		start += end + 1
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), bytes.TrimSuffix(data, []byte{'\n'}), nil
+	}
+	return 0, nil, nil
+}
+
+// splitCSV splits csvlog into records, which end at the first newline outside
+// quotes.
+func splitCSV(data []byte, atEOF bool) (int, []byte, error) {
+	quoted := false
+	for i, c := range data {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\n':
+			if !quoted {
+				return i + 1, data[:i], nil
+			}
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// ProcessRecord is invoked for every log entry. It returns the entry as JSON,
+// or an error if it cannot be parsed.
+func (p *PostgresqlProfile) ProcessRecord(record string) (interface{}, error) {
+	var values map[string]string
+	var err error
+	if p.Format == "csvlog" {
+		values, err = parseCSV(record)
+	} else {
+		values, err = p.parseStderr(record)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	message := values["message"]
+	if match := sqlStateRe.FindStringSubmatch(message); match != nil {
+		values["sql_state_code"], message = match[1], match[2]
+	}
+	values["message"] = message
+	if match := durationRe.FindStringSubmatch(message); match != nil {
+		values["duration_ms"], message = match[1], match[2]
+	}
+	if match := statementRe.FindStringSubmatch(message); match != nil {
+		values["statement"] = match[1]
+	}
+
+	fields := make(map[string]interface{}, len(values)+1)
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		switch {
+		case intFields[name]:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("logtailer.postgresql: bad %s %q", name, value)
+			}
+			fields[name] = n
+		case name == "duration_ms":
+			fields[name], _ = strconv.ParseFloat(value, 64)
+		case name == "time" || name == "session_start_time":
+			fields[name] = parseTime(value)
+		default:
+			fields[name] = value
+		}
+	}
+	if statement := values["statement"]; statement != "" {
+		fields["statement_signature"] = Signature(statement)
+	}
+	return json.Marshal(fields)
+}
+
+// parseStderr returns the fields of an entry of a stderr log.
+func (p *PostgresqlProfile) parseStderr(record string) (map[string]string, error) {
+	var values map[string]string
+	// field is the field the line being read goes to
+	var field string
+	for _, line := range strings.Split(record, "\n") {
+		prefixFields, severity, message, ok := p.prefix.match(line)
+		switch {
+		case values == nil && !ok:
+			return nil, fmt.Errorf("logtailer.postgresql: line does not match log_line_prefix: %s", line)
+		case values == nil:
+			values = prefixFields
+			values["error_severity"] = severity
+			field = "message"
+		case ok && continuations[severity] != "":
+			field = continuations[severity]
+		default:
+			// a line of a multi-line message, indented with a tab
+			values[field] += "\n" + strings.TrimPrefix(line, "\t")
+			continue
+		}
+		values[field] = message
+	}
+	return values, nil
+}
+
+// parseCSV returns the fields of a csvlog record.
+func parseCSV(record string) (map[string]string, error) {
+	r := csv.NewReader(strings.NewReader(record))
+	r.FieldsPerRecord = -1
+	columns, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("logtailer.postgresql: bad csvlog record: %v", err)
+	}
+	if len(columns) < 14 {
+		return nil, fmt.Errorf("logtailer.postgresql: csvlog record has %d columns: %s", len(columns), record)
+	}
+	values := make(map[string]string, len(columns))
+	for i, value := range columns {
+		if i < len(csvColumns) {
+			values[csvColumns[i]] = value
+		}
+	}
+	return values, nil
+}
+
+// parseTime parses the time of a log line, or the seconds since the epoch of
+// %n, leaving it as it is if it cannot.
+func parseTime(value string) interface{} {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999 MST", "2006-01-02 15:04:05.999999999 -07"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*1e9)).UTC()
+	}
+	return value
+}
@@ -0,0 +1,168 @@
+package postgresql
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/facebookgo/ensure"
+)
+
+const stderrLog = `2016-01-02 15:04:05.123 UTC [1234] app@parse LOG:  duration: 12.345 ms  statement: SELECT *
+		FROM users
+		WHERE name = 'bob'
+2016-01-02 15:04:06.000 UTC [1235] app@parse ERROR:  23505: duplicate key value violates unique constraint "users_pkey"
+2016-01-02 15:04:06.000 UTC [1235] app@parse DETAIL:  Key (id)=(42) already exists.
+2016-01-02 15:04:06.000 UTC [1235] app@parse STATEMENT:  INSERT INTO users (id, name) VALUES (42, 'bob')
+2016-01-02 15:04:07.000 UTC [99] LOG:  checkpoint starting: time
+`
+
+const csvLog = `2016-01-02 15:04:05.123 UTC,"app","parse",1234,"10.0.0.1:5432",5687f1a5.4d2,3,"SELECT",2016-01-02 15:00:00 UTC,3/12,0,LOG,00000,"duration: 0.512 ms  execute <unnamed>: SELECT name FROM users WHERE id = $1","parameters: $1 = '42'",,,,,,,,"psql"
+2016-01-02 15:04:06.000 UTC,"app","parse",1235,"10.0.0.1:5433",5687f1a6.4d3,1,"INSERT",2016-01-02 15:00:01 UTC,4/7,1001,ERROR,23505,"duplicate key value violates unique constraint ""users_pkey""","Key (id)=(42) already exists.",,,,,"INSERT INTO users (id, name)
+VALUES (42, 'bob')",,,"psql"
+`
+
+func newProfile(t *testing.T, options profiles.Options) *PostgresqlProfile {
+	p, err := New(options)
+	ensure.Nil(t, err)
+	ensure.Nil(t, p.Init())
+	return p.(*PostgresqlProfile)
+}
+
+// process splits log into entries and returns the fields of each.
+func process(t *testing.T, p *PostgresqlProfile, log string) []map[string]interface{} {
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	scanner.Split(p.Split)
+	var entries []map[string]interface{}
+	for scanner.Scan() {
+		out, err := p.ProcessRecord(scanner.Text())
+		ensure.Nil(t, err)
+		var fields map[string]interface{}
+		ensure.Nil(t, json.Unmarshal(out.([]byte), &fields))
+		entries = append(entries, fields)
+	}
+	ensure.Nil(t, scanner.Err())
+	return entries
+}
+
+func TestStderr(t *testing.T) {
+	p := newProfile(t, profiles.Options{"log_line_prefix": "%m [%p] %q%u@%d "})
+	entries := process(t, p, stderrLog)
+	ensure.DeepEqual(t, len(entries), 3)
+
+	ensure.DeepEqual(t, entries[0], map[string]interface{}{
+		"time":                "2016-01-02T15:04:05.123Z",
+		"process_id":          float64(1234),
+		"user_name":           "app",
+		"database_name":       "parse",
+		"error_severity":      "LOG",
+		"message":             "duration: 12.345 ms  statement: SELECT *\n\tFROM users\n\tWHERE name = 'bob'",
+		"duration_ms":         12.345,
+		"statement":           "SELECT *\n\tFROM users\n\tWHERE name = 'bob'",
+		"statement_signature": "select * from users where name = ?",
+	})
+
+	ensure.DeepEqual(t, entries[1]["error_severity"], "ERROR")
+	ensure.DeepEqual(t, entries[1]["sql_state_code"], "23505")
+	ensure.DeepEqual(t, entries[1]["message"], `duplicate key value violates unique constraint "users_pkey"`)
+	ensure.DeepEqual(t, entries[1]["detail"], "Key (id)=(42) already exists.")
+	ensure.DeepEqual(t, entries[1]["statement"], "INSERT INTO users (id, name) VALUES (42, 'bob')")
+	ensure.DeepEqual(t, entries[1]["statement_signature"], "insert into users (id, name) values (?+)")
+
+	// a process without a session leaves out what follows %q
+	ensure.DeepEqual(t, entries[2]["process_id"], float64(99))
+	ensure.DeepEqual(t, entries[2]["message"], "checkpoint starting: time")
+	_, ok := entries[2]["user_name"]
+	ensure.False(t, ok)
+}
+
+func TestStderrSplitHoldsBackLastEntry(t *testing.T) {
+	p := newProfile(t, nil)
+	entry := "2016-01-02 15:04:06.000 UTC [1235] ERROR:  syntax error at or near \"SELEC\"\n"
+	advance, token, err := p.Split([]byte(entry), false)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, advance, 0)
+	ensure.True(t, token == nil)
+
+	// the statement belongs to the error
+	more := entry + "2016-01-02 15:04:06.000 UTC [1235] STATEMENT:  SELEC 1\n"
+	advance, token, err = p.Split([]byte(more), false)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, advance, 0)
+
+	next := more + "2016-01-02 15:04:07.000 UTC [1236] LOG:  duration: 1.000 ms\n"
+	advance, token, err = p.Split([]byte(next), false)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, advance, len(more))
+	ensure.DeepEqual(t, string(token), strings.TrimSuffix(more, "\n"))
+}
+
+func TestCSVLog(t *testing.T) {
+	p := newProfile(t, profiles.Options{"format": "csvlog"})
+	entries := process(t, p, csvLog)
+	ensure.DeepEqual(t, len(entries), 2)
+
+	ensure.DeepEqual(t, entries[0], map[string]interface{}{
+		"time":                   "2016-01-02T15:04:05.123Z",
+		"user_name":              "app",
+		"database_name":          "parse",
+		"process_id":             float64(1234),
+		"connection_from":        "10.0.0.1:5432",
+		"session_id":             "5687f1a5.4d2",
+		"session_line_num":       float64(3),
+		"command_tag":            "SELECT",
+		"session_start_time":     "2016-01-02T15:00:00Z",
+		"virtual_transaction_id": "3/12",
+		"transaction_id":         float64(0),
+		"error_severity":         "LOG",
+		"sql_state_code":         "00000",
+		"message":                "duration: 0.512 ms  execute <unnamed>: SELECT name FROM users WHERE id = $1",
+		"detail":                 "parameters: $1 = '42'",
+		"application_name":       "psql",
+		"duration_ms":            0.512,
+		"statement":              "SELECT name FROM users WHERE id = $1",
+		"statement_signature":    "select name from users where id = ?",
+	})
+
+	ensure.DeepEqual(t, entries[1]["sql_state_code"], "23505")
+	ensure.DeepEqual(t, entries[1]["message"], `duplicate key value violates unique constraint "users_pkey"`)
+	ensure.DeepEqual(t, entries[1]["statement"], "INSERT INTO users (id, name)\nVALUES (42, 'bob')")
+	ensure.DeepEqual(t, entries[1]["statement_signature"], "insert into users (id, name) values (?+)")
+}
+
+func TestStructLiteral(t *testing.T) {
+	p := &PostgresqlProfile{Format: "stderr", LogLinePrefix: DefaultLogLinePrefix}
+	ensure.Nil(t, p.Init())
+	out, err := p.ProcessRecord("2016-01-02 15:04:07.000 UTC [99] LOG:  checkpoint starting: time")
+	ensure.Nil(t, err)
+	var fields map[string]interface{}
+	ensure.Nil(t, json.Unmarshal(out.([]byte), &fields))
+	ensure.DeepEqual(t, fields["message"], "checkpoint starting: time")
+
+	ensure.NotNil(t, (&PostgresqlProfile{Format: "jsonlog"}).Init())
+}
+
+func TestBadInput(t *testing.T) {
+	p := newProfile(t, nil)
+	_, err := p.ProcessRecord("not a postgresql log line")
+	ensure.NotNil(t, err)
+
+	_, err = New(profiles.Options{"log_line_prefix": "%Z "})
+	ensure.NotNil(t, err)
+	_, err = New(profiles.Options{"format": "jsonlog"})
+	ensure.NotNil(t, err)
+}
+
+func TestSignature(t *testing.T) {
+	for _, c := range []struct{ statement, signature string }{
+		{"SELECT * FROM t WHERE id IN (1, 2, 3)", "select * from t where id in (?+)"},
+		{`SELECT "Col1" FROM t2 WHERE a = E'it\'s' AND b = 'it''s'`, `select "Col1" from t2 where a = ? and b = ?`},
+		{"SELECT $$a 'quoted' body$$, $fn$x$fn$, 1.5e3 -- note\nFROM t", "select ?, ?, ? from t"},
+		{"UPDATE t SET n = n + 1 /* app */ WHERE id = $1;", "update t set n = n + ? where id = ?"},
+		{"INSERT INTO t VALUES ($1, $2), ($3, $4)", "insert into t values (?+)"},
+	} {
+		ensure.DeepEqual(t, Signature(c.statement), c.signature, c.statement)
+	}
+}
@@ -0,0 +1,115 @@
+package postgresql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultLogLinePrefix is the default log_line_prefix of PostgreSQL 10 and
+// later.
+const DefaultLogLinePrefix = "%m [%p] "
+
+// timePattern matches the times of %m, %t and %s, with or without
+// milliseconds.
+const timePattern = `\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)? [^\s\]]+`
+
+// severities are the severities a log line may have, the last of them those
+// of the lines that continue an entry.
+const severities = `DEBUG[1-5]|LOG|INFO|NOTICE|WARNING|ERROR|FATAL|PANIC|DETAIL|HINT|STATEMENT|CONTEXT|QUERY|LOCATION`
+
+// prefixEscapes are the fields and patterns of the log_line_prefix escapes.
+// Fields are named after the columns of csvlog.
+var prefixEscapes = map[byte]struct{ name, pattern string }{
+	'a': {"application_name", `.*?`},
+	'u': {"user_name", `.*?`},
+	'd': {"database_name", `.*?`},
+	'r': {"connection_from", `.*?`},
+	'h': {"connection_from", `.*?`},
+	'b': {"backend_type", `.*?`},
+	'p': {"process_id", `\d+`},
+	'P': {"leader_pid", `\d*`},
+	't': {"time", timePattern},
+	'm': {"time", timePattern},
+	'n': {"time", `\d+\.\d+`},
+	'i': {"command_tag", `.*?`},
+	'e': {"sql_state_code", `[0-9A-Z]{5}`},
+	'c': {"session_id", `[0-9a-f]+\.[0-9a-f]+`},
+	'l': {"session_line_num", `\d+`},
+	's': {"session_start_time", timePattern},
+	'v': {"virtual_transaction_id", `[^\s\]]*`},
+	'x': {"transaction_id", `\d+`},
+	'Q': {"query_id", `-?\d+`},
+}
+
+// A prefix matches the start of the lines of a stderr log: the
+// log_line_prefix, the severity and the two spaces before the message.
+type prefix struct {
+	re *regexp.Regexp
+	// fields names the groups of re that hold prefix escapes. The severity
+	// is in the group after them.
+	fields []string
+}
+
+// compilePrefix turns a log_line_prefix into a prefix.
+func compilePrefix(logLinePrefix string) (*prefix, error) {
+	p := &prefix{}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	optional := false
+	for i := 0; i < len(logLinePrefix); i++ {
+		c := logLinePrefix[i]
+		if c != '%' {
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+			continue
+		}
+		if i++; i == len(logLinePrefix) {
+			return nil, fmt.Errorf("log_line_prefix %q ends with %%", logLinePrefix)
+		}
+		switch c = logLinePrefix[i]; c {
+		case '%':
+			pattern.WriteString("%")
+		case 'q':
+			// what follows is left out by processes without a session
+			if !optional {
+				pattern.WriteString("(?:")
+				optional = true
+			}
+		default:
+			escape, ok := prefixEscapes[c]
+			if !ok {
+				return nil, fmt.Errorf("log_line_prefix %q has unknown escape %%%c", logLinePrefix, c)
+			}
+			pattern.WriteString("(" + escape.pattern + ")")
+			p.fields = append(p.fields, escape.name)
+		}
+	}
+	if optional {
+		pattern.WriteString(")?")
+	}
+	pattern.WriteString("(" + severities + "):  ")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf("bad log_line_prefix %q: %v", logLinePrefix, err)
+	}
+	p.re = re
+	return p, nil
+}
+
+// match returns the fields of the prefix of line, its severity and the rest of
+// the line, or false if line does not start with the prefix.
+func (p *prefix) match(line string) (fields map[string]string, severity, message string, ok bool) {
+	m := p.re.FindStringSubmatchIndex(line)
+	if m == nil {
+		return nil, "", "", false
+	}
+	fields = make(map[string]string, len(p.fields))
+	for i, name := range p.fields {
+		if start := m[2+2*i]; start >= 0 {
+			fields[name] = line[start:m[3+2*i]]
+		}
+	}
+	n := len(p.fields)
+	return fields, line[m[2+2*n]:m[3+2*n]], line[m[1]:], true
+}
@@ -0,0 +1,54 @@
+package postgresql
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ParsePlatform/logtailer/profiles/helpers"
+)
+
+// dollarTagRe matches the opening tag of a dollar-quoted string.
+var dollarTagRe = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+
+// dialect is how PostgreSQL quotes, escapes and comments.
+var dialect = &helpers.SQLDialect{
+	StringQuotes:     `'`,
+	IdentifierQuotes: `"`,
+	Literal:          literal,
+}
+
+// Signature scrubs the literals from a SQL statement, so that statements
+// differing only in their literals have the same signature, see
+// helpers.ScrubSQL. Dollar-quoted strings, escape strings like E'a\nb' and
+// parameters like $1 are literals too.
+func Signature(statement string) string {
+	return helpers.ScrubSQL(statement, dialect)
+}
+
+// literal returns the index of the last character of the dollar-quoted
+// string, parameter or escape string starting at statement[i], or -1.
+func literal(statement string, i int) int {
+	switch statement[i] {
+	case '$':
+		if tag := dollarTagRe.FindString(statement[i:]); tag != "" {
+			end := strings.Index(statement[i+len(tag):], tag)
+			if end < 0 {
+				return len(statement) - 1
+			}
+			return i + len(tag) + end + len(tag) - 1
+		}
+		end := i
+		for end+1 < len(statement) && statement[end+1] >= '0' && statement[end+1] <= '9' {
+			end++
+		}
+		if end > i {
+			return end
+		}
+	case 'e', 'E':
+		// escape strings, E'...', allow backslash escapes
+		if i+1 < len(statement) && statement[i+1] == '\'' {
+			return helpers.SkipSQLString(statement, i+1, true)
+		}
+	}
+	return -1
+}
@@ -1,7 +1,16 @@
 // Package profiles describes the logtailer Profile interface and provides a
-// simple registry
+// simple registry. Profile packages register their profile in init, so a
+// binary offers every profile whose package it imports:
+//
+//	import _ "github.com/ParsePlatform/logtailer/profiles/mongodb"
 package profiles
 
+import (
+	"time"
+
+	"github.com/ParsePlatform/logtailer/sinks"
+)
+
 // A Profile is a log consumer.
 type Profile interface {
 	// The unique identifier for the profile.
@@ -17,3 +26,84 @@ type Profile interface {
 	// to your profile should go
 	Init() error
 }
+
+// An AckingProfile is a Profile whose output reports which records it has
+// delivered, so that logtailer only checkpoints past records that will not be
+// lost if the run fails.
+type AckingProfile interface {
+	Profile
+	// HandleOutputAck behaves like HandleOutput, but calls ack(n) once the next
+	// n records received, in the order received, have been delivered. A record
+	// that fails to send should be reported on errors and left unacknowledged
+	// along with every record after it, so that they are read again by the next
+	// run.
+	HandleOutputAck(records <-chan interface{}, dryRun bool, ack func(n int)) (errors <-chan error)
+}
+
+// StdoutOutput prints the output of a profile to stdout, one record per line,
+// and acknowledges each record once printed, which lets logtailer checkpoint
+// past it. Profiles with no output of their own embed it to implement
+// HandleOutput and AckingProfile.
+type StdoutOutput struct{}
+
+// HandleOutput prints the output to stdout.
+func (o StdoutOutput) HandleOutput(records <-chan interface{}, dryRun bool) <-chan error {
+	return o.HandleOutputAck(records, dryRun, func(int) {})
+}
+
+// HandleOutputAck prints the output to stdout and acknowledges it.
+func (o StdoutOutput) HandleOutputAck(records <-chan interface{}, dryRun bool, ack func(n int)) <-chan error {
+	return sinks.Output(sinks.NewStdout(), records, ack)
+}
+
+// A Record is a single input record along with where and when it was read.
+type Record struct {
+	Text string
+	// Truncated is set when Text is only the start of a record that was longer
+	// than the maximum record size.
+	Truncated bool
+	// Source is the path of the file the record was read from, "-" for stdin.
+	Source string
+	// Offset is the byte offset of the start of the record in Source.
+	Offset int64
+	// Line is the line number in Source that the record starts on, counting
+	// from 1. It is relative to where logtailer started reading if the file was
+	// first read by an older version.
+	Line int64
+	// ReadTime is when logtailer read the record.
+	ReadTime time.Time
+	// Hostname is the name of the host logtailer runs on.
+	Hostname string
+}
+
+// A RecordProfile is a Profile that wants to know where each record came from.
+// ProcessFullRecord is called instead of ProcessRecord.
+type RecordProfile interface {
+	Profile
+	ProcessFullRecord(record *Record) (result interface{}, err error)
+}
+
+// A SinkProfile is a Profile whose HandleOutput does more than deliver records,
+// for instance by combining several of them into one. When logtailer is given
+// a sink it keeps calling HandleOutput, and SetSink beforehand to have the
+// output delivered to the sink, which is already open.
+type SinkProfile interface {
+	Profile
+	SetSink(sink sinks.Sink)
+}
+
+// A Reloader is a Profile that reads files of its own, like a mapping of keys
+// to users, and can re-read them while running, without losing state such as
+// partly built events. The logtailer daemon calls Reload on SIGHUP.
+type Reloader interface {
+	Profile
+	Reload() error
+}
+
+// A MetricsProfile is a Profile that counts things of its own, like events it
+// has built from several records. logtailer exports each counter along with
+// its own metrics as logtailer_profile_NAME.
+type MetricsProfile interface {
+	Profile
+	Counters() map[string]float64
+}
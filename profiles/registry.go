@@ -0,0 +1,61 @@
+package profiles
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A Factory creates a new instance of a profile configured by options. Each
+// instance has its own state, so that several can run side by side with
+// different options.
+type Factory func(options Options) (Profile, error)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a profile available by name. Profile packages call it from
+// init, so that importing a package is enough to make its profile available.
+// It panics if the name is already taken.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("profiles: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("profiles: Register called twice for profile %s", name))
+	}
+	registry[name] = factory
+}
+
+// Lookup returns the factory of the profile registered as name.
+func Lookup(name string) (Factory, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// New returns a new instance of the profile registered as name.
+func New(name string, options Options) (Profile, error) {
+	factory, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	return factory(options)
+}
+
+// List returns the names of the registered profiles, sorted.
+func List() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
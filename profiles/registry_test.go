@@ -0,0 +1,64 @@
+package profiles
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+)
+
+type testProfile struct {
+	Profile
+	greeting string
+}
+
+func (p *testProfile) Name() string { return "registry-test" }
+
+func newTestProfile(options Options) (Profile, error) {
+	return &testProfile{greeting: options.String("greeting", "hello")}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register("registry-test", newTestProfile)
+	ensure.StringContains(t, strings.Join(List(), ","), "registry-test")
+
+	_, ok := Lookup("registry-test")
+	ensure.True(t, ok)
+	p, err := New("registry-test", nil)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, p.(*testProfile).greeting, "hello")
+	other, err := New("registry-test", Options{"greeting": "hi"})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, other.(*testProfile).greeting, "hi")
+	ensure.DeepEqual(t, p.(*testProfile).greeting, "hello")
+
+	_, ok = Lookup("missing")
+	ensure.False(t, ok)
+	_, err = New("missing", nil)
+	ensure.NotNil(t, err)
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	Register("registry-twice", newTestProfile)
+	defer func() {
+		ensure.NotNil(t, recover())
+	}()
+	Register("registry-twice", newTestProfile)
+}
+
+func TestOptions(t *testing.T) {
+	o := Options{"on": "true", "n": "3", "d": "2s", "bad": "x"}
+	ensure.DeepEqual(t, o.String("missing", "def"), "def")
+	b, err := o.Bool("on", false)
+	ensure.Nil(t, err)
+	ensure.True(t, b)
+	n, err := o.Int("n", 0)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, n, 3)
+	d, err := o.Duration("d", 0)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, d, 2*time.Second)
+	_, err = o.Int("bad", 0)
+	ensure.NotNil(t, err)
+}
@@ -11,13 +11,14 @@ import (
 
 var authorizedKeyPath = flag.String("authorized_keys_path", "/home/ubuntu/.ssh/authorized_keys", "path to authorized keys path to provide fingerprint to user mapping")
 
-func populateKeyMapping() map[string]string {
+// populateKeyMapping maps the fingerprints of the keys in the authorized_keys
+// file at path to users. Lines that cannot be parsed are logged and skipped.
+func populateKeyMapping(path string) (map[string]string, error) {
 	result := make(map[string]string)
 
-	f, err := ioutil.ReadFile(*authorizedKeyPath)
+	f, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Println("logtailer.sshd error reading authorized_keys:", err)
-		return result
+		return result, err
 	}
 	scanner := bufio.NewScanner(bytes.NewBuffer(f))
 
@@ -36,5 +37,5 @@ func populateKeyMapping() map[string]string {
 		username := strings.Split(key.Comment, "@")[0]
 		result[string(key.Fingerprint())] = username
 	}
-	return result
+	return result, nil
 }
@@ -9,13 +9,35 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/ParsePlatform/logtailer/sinks"
 )
 
+func init() {
+	profiles.Register("sshd", New)
+}
+
+// New creates an SshdProfile. The authorized_keys_path option sets
+// AuthorizedKeysPath, which defaults to the -authorized_keys_path flag.
+func New(options profiles.Options) (profiles.Profile, error) {
+	return &SshdProfile{
+		AuthorizedKeysPath: options.String("authorized_keys_path", *authorizedKeyPath),
+	}, nil
+}
+
 // SshdProfile is a logtailer profile that parses ssh login events from sshd logs
 type SshdProfile struct {
-	// maps key fingerprints to fb users
+	// AuthorizedKeysPath is the authorized_keys file that maps key fingerprints
+	// to fb users.
+	AuthorizedKeysPath string
+
+	// maps key fingerprints to fb users, replaced by Reload
 	fingerprintToFbUser map[string]string
+	keysMu              sync.RWMutex
 
 	// events is the in-flight ssh events that are being built up
 	events map[string]*sshEvent
@@ -23,6 +45,13 @@ type SshdProfile struct {
 	// completeEvents is populated with finished events
 	completeEvents chan *sshEvent
 
+	// completed, timedOut and flushed count the events written out because
+	// they were complete, expired, or in flight when logtailer stopped
+	completed, timedOut, flushed int64
+
+	// sink receives complete events, stdout if not set
+	sink sinks.Sink
+
 	// Logger is used to report tailer issues to stderr
 	logger *log.Logger
 }
@@ -81,10 +110,39 @@ func (p *SshdProfile) Init() error {
 	p.logger = log.New(os.Stderr, "DEBUG: ", log.LstdFlags|log.Lshortfile)
 	p.completeEvents = make(chan *sshEvent)
 	p.events = make(map[string]*sshEvent)
-	p.fingerprintToFbUser = populateKeyMapping()
+	mapping, err := populateKeyMapping(p.AuthorizedKeysPath)
+	if err != nil {
+		p.logger.Println("logtailer.sshd error reading authorized_keys:", err)
+	}
+	p.keysMu.Lock()
+	p.fingerprintToFbUser = mapping
+	p.keysMu.Unlock()
 	return nil
 }
 
+// Reload re-reads the authorized_keys file, keeping the events in flight. The
+// previous mapping is kept if the file cannot be read.
+func (p *SshdProfile) Reload() error {
+	mapping, err := populateKeyMapping(p.AuthorizedKeysPath)
+	if err != nil {
+		return fmt.Errorf("logtailer.sshd error reading authorized_keys: %v", err)
+	}
+	p.keysMu.Lock()
+	p.fingerprintToFbUser = mapping
+	p.keysMu.Unlock()
+	return nil
+}
+
+// Shard keys lines by the sshd process that logged them, so that the lines of
+// one connection are handled in order even with multiple workers.
+func (p *SshdProfile) Shard(line string) string {
+	res := sshLogRe.FindStringSubmatch(line)
+	if res == nil {
+		return ""
+	}
+	return res[2] + ":" + res[3]
+}
+
 // ProcessRecord is invoked for every input log line. It returns a transformed.
 // line or an error
 func (p *SshdProfile) ProcessRecord(line string) (interface{}, error) {
@@ -112,7 +170,9 @@ func (p *SshdProfile) ProcessRecord(line string) (interface{}, error) {
 		res = foundKeyRe.FindStringSubmatch(message)
 		partialEvent.PeType = "foundKey"
 		partialEvent.Fingerprint = res[1]
+		p.keysMu.RLock()
 		partialEvent.FbUser = p.fingerprintToFbUser[res[1]]
+		p.keysMu.RUnlock()
 	case acceptKeyRe.MatchString(message):
 		res = acceptKeyRe.FindStringSubmatch(message)
 		partialEvent.PeType = "acceptKey"
@@ -168,8 +228,8 @@ func (p *SshdProfile) handlePartialEvent(partialEvent []byte) error {
 	// TODO nuke this and replace it with a reflection that sets values in
 	// fullEvent for all non-nil fields in event
 	//
-	// If we run with multiple workers we have a data race here and should move
-	// Complete calculation after the switch
+	// Lines of one connection arrive here in order since Shard keeps them on
+	// the same worker.
 	switch event.PeType {
 	case "connectLine":
 		fullEvent.SrcIP = event.SrcIP
@@ -200,6 +260,7 @@ func (p *SshdProfile) handlePartialEvent(partialEvent []byte) error {
 	}
 	// if complete
 	if fullEvent.Complete {
+		atomic.AddInt64(&p.completed, 1)
 		p.completeEvents <- fullEvent
 		delete(p.events, key)
 	}
@@ -207,61 +268,112 @@ func (p *SshdProfile) handlePartialEvent(partialEvent []byte) error {
 	return nil
 }
 
+// Counters makes the profile a profiles.MetricsProfile, counting the events
+// written out by how they ended.
+func (p *SshdProfile) Counters() map[string]float64 {
+	return map[string]float64{
+		"sshd_events_completed_total": float64(atomic.LoadInt64(&p.completed)),
+		"sshd_events_timed_out_total": float64(atomic.LoadInt64(&p.timedOut)),
+		"sshd_events_flushed_total":   float64(atomic.LoadInt64(&p.flushed)),
+	}
+}
+
+// SetSink has complete events written to sink instead of stdout.
+func (p *SshdProfile) SetSink(sink sinks.Sink) {
+	p.sink = sink
+}
+
 // HandleOutput recieves a channel of input lines and a flag of whether or not
 // this is a dry run being invoked (to avoid side-effects).
 //
 // The return value is a channel of errors. logtailer keeps track of
 // the number of errors and exits non-zero if they are over a threshold.
+//
+// Once records is closed the events still in flight are written out as
+// incomplete, and the error channel is closed after the last event is written.
+// Errors writing events to the sink are sent on it too.
 func (p *SshdProfile) HandleOutput(records <-chan interface{}, dryRun bool) <-chan error {
 	errChan := make(chan error)
-	timeoutCheckTicker := time.NewTicker(5 * time.Second)
+	written := make(chan struct{})
 
 	// Launch the consumption goroutine.
 	go func() {
-		// Close errChan when this goroutine finishes to signal being done.
-		defer close(errChan)
+		// Close errChan once every event is written to signal being done.
+		defer func() {
+			close(p.completeEvents)
+			<-written
+			close(errChan)
+		}()
+
+		// expire events that aren't updated for 60s
+		timeoutCheckTicker := time.NewTicker(5 * time.Second)
+		defer timeoutCheckTicker.Stop()
 
 		// Consume lines input channel until it is closed (if consuming stdin),
 		// this is potentially never.
-		for record := range records {
-			line, ok := record.([]byte)
-			if !ok {
-				errChan <- fmt.Errorf("Unexpected output record type: %t", record)
-				continue
-			}
-			if err := p.handlePartialEvent(line); err != nil {
-				errChan <- err
-			}
-		}
-		// if we're closing up shop make sure the events cleaner goroutine stops too.
-		timeoutCheckTicker.Stop()
-	}()
-
-	// expire events that aren't updated for 60s
-	go func() {
 		for {
-			<-timeoutCheckTicker.C
-			for key, event := range p.events {
-				expireEventAt := event.Timestamp.Add(60 * time.Second)
-				if time.Now().After(expireEventAt) {
-					event.Success = false
-					event.FailReason = "timeout waiting for complete event"
-					p.completeEvents <- event
-					delete(p.events, key)
+			select {
+			case record, ok := <-records:
+				if !ok {
+					// closing up shop, don't lose the events in flight
+					p.flushEvents("logtailer stopped before event completed")
+					return
+				}
+				line, ok := record.([]byte)
+				if !ok {
+					errChan <- fmt.Errorf("Unexpected output record type: %t", record)
+					continue
+				}
+				if err := p.handlePartialEvent(line); err != nil {
+					errChan <- err
+				}
+			case now := <-timeoutCheckTicker.C:
+				for key, event := range p.events {
+					expireEventAt := event.Timestamp.Add(60 * time.Second)
+					if now.After(expireEventAt) {
+						event.Success = false
+						event.FailReason = "timeout waiting for complete event"
+						atomic.AddInt64(&p.timedOut, 1)
+						p.completeEvents <- event
+						delete(p.events, key)
+					}
 				}
 			}
 		}
 	}()
 
 	go func() {
-		// write events to stdout
+		defer close(written)
+		// write events to the sink
+		sink := p.sink
+		if sink == nil {
+			sink = sinks.NewStdout()
+		}
 		for event := range p.completeEvents {
 			message := event.String()
-			if len(message) > 0 {
-				fmt.Println(message)
+			if len(message) == 0 {
+				continue
+			}
+			if err := sink.Write([][]byte{[]byte(message)}); err != nil {
+				errChan <- err
+				continue
+			}
+			if err := sink.Flush(); err != nil {
+				errChan <- err
 			}
 		}
 	}()
 
 	return errChan
 }
+
+// flushEvents writes out the events in flight as failed for reason.
+func (p *SshdProfile) flushEvents(reason string) {
+	for key, event := range p.events {
+		event.Success = false
+		event.FailReason = reason
+		atomic.AddInt64(&p.flushed, 1)
+		p.completeEvents <- event
+		delete(p.events, key)
+	}
+}
@@ -0,0 +1,113 @@
+package sshd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/ParsePlatform/logtailer/sinks"
+	"github.com/facebookgo/ensure"
+)
+
+func TestShard(t *testing.T) {
+	p := &SshdProfile{}
+	ensure.DeepEqual(t, p.Shard("Oct 10 22:05:24 host-1 sshd[4321]: Connection from 10.0.0.1 port 22"), "host-1:4321")
+	ensure.DeepEqual(t, p.Shard("Oct 10 22:05:24 host-1 cron[99]: job"), "")
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshd")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "authorized_keys")
+
+	p, err := New(profiles.Options{"authorized_keys_path": path})
+	ensure.Nil(t, err)
+	ensure.Nil(t, p.Init())
+	user := func() string {
+		out, err := p.ProcessRecord("Oct 10 22:05:24 host-1 sshd[4321]: Found matching RSA key: b5:ca:16:03:d4:10:41:80:3d:bc:3b:18:05:57:4f:56")
+		ensure.Nil(t, err)
+		var event sshEvent
+		ensure.Nil(t, json.Unmarshal(out.([]byte), &event))
+		return event.FbUser
+	}
+	ensure.DeepEqual(t, user(), "")
+
+	// a key added to the file is picked up
+	ensure.Nil(t, ioutil.WriteFile(path, []byte(testKey+"\n"), 0644))
+	ensure.Nil(t, p.(profiles.Reloader).Reload())
+	ensure.DeepEqual(t, user(), "test")
+
+	// and kept if the file goes missing
+	ensure.Nil(t, os.Remove(path))
+	ensure.NotNil(t, p.(profiles.Reloader).Reload())
+	ensure.DeepEqual(t, user(), "test")
+}
+
+func TestHandleOutputFlushesEventsInFlight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshd")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	p := &SshdProfile{AuthorizedKeysPath: filepath.Join(dir, "authorized_keys")}
+	ensure.Nil(t, p.Init())
+	sink := sinks.NewFile(out)
+	ensure.Nil(t, sink.Open())
+	p.SetSink(sink)
+
+	records := make(chan interface{})
+	errChan := p.HandleOutput(records, false)
+	line, err := p.ProcessRecord("Oct 10 22:05:24 host-1 sshd[4321]: Connection from 10.0.0.1 port 22")
+	ensure.Nil(t, err)
+	records <- line
+	close(records)
+	for err := range errChan {
+		ensure.Nil(t, err)
+	}
+	ensure.Nil(t, sink.Close())
+
+	buf, err := ioutil.ReadFile(out)
+	ensure.Nil(t, err)
+	var event sshEvent
+	ensure.Nil(t, json.Unmarshal(buf, &event))
+	ensure.DeepEqual(t, event.SrcIP, "10.0.0.1")
+	ensure.False(t, event.Success)
+	ensure.DeepEqual(t, event.FailReason, "logtailer stopped before event completed")
+	ensure.DeepEqual(t, p.Counters()["sshd_events_flushed_total"], float64(1))
+}
+
+// failingSink fails every write.
+type failingSink struct{}
+
+func (failingSink) Open() error                  { return nil }
+func (failingSink) Write(records [][]byte) error { return errors.New("sink unavailable") }
+func (failingSink) Flush() error                 { return nil }
+func (failingSink) Close() error                 { return nil }
+
+func TestHandleOutputReportsSinkErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshd")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := &SshdProfile{AuthorizedKeysPath: filepath.Join(dir, "authorized_keys")}
+	ensure.Nil(t, p.Init())
+	p.SetSink(failingSink{})
+
+	records := make(chan interface{})
+	errChan := p.HandleOutput(records, false)
+	line, err := p.ProcessRecord("Oct 10 22:05:24 host-1 sshd[4321]: Connection from 10.0.0.1 port 22")
+	ensure.Nil(t, err)
+	records <- line
+	close(records)
+	var errs []error
+	for err := range errChan {
+		errs = append(errs, err)
+	}
+	ensure.DeepEqual(t, len(errs), 1)
+	ensure.DeepEqual(t, errs[0].Error(), "sink unavailable")
+}
@@ -0,0 +1,262 @@
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// now returns the current time, which gives the year of RFC 3164 timestamps.
+var now = time.Now
+
+// A Message is a syslog message, in either the RFC 3164 (BSD) or the
+// RFC 5424 format. Fields the message does not have are left empty.
+type Message struct {
+	// Priority is the PRI of the message, or -1 if it has none, as in the log
+	// files written by syslog daemons.
+	Priority int
+	// Version is 1 for RFC 5424 messages and 0 for RFC 3164 ones.
+	Version   int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	// StructuredData holds the parameters of each SD-ELEMENT by SD-ID.
+	StructuredData map[string]map[string]string
+	// Message is the rest of the line, the message body.
+	Message string
+}
+
+// Facility returns the facility of the message, or -1 if it has no priority.
+func (m *Message) Facility() int {
+	if m.Priority < 0 {
+		return -1
+	}
+	return m.Priority / 8
+}
+
+// Severity returns the severity of the message, or -1 if it has no priority.
+func (m *Message) Severity() int {
+	if m.Priority < 0 {
+		return -1
+	}
+	return m.Priority % 8
+}
+
+var (
+	facilityNames = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+)
+
+// FacilityName returns the name of the facility of the message, like auth or
+// local0, or "" if it has no priority.
+func (m *Message) FacilityName() string {
+	if f := m.Facility(); f >= 0 && f < len(facilityNames) {
+		return facilityNames[f]
+	}
+	return ""
+}
+
+// SeverityName returns the name of the severity of the message, like err or
+// info, or "" if it has no priority.
+func (m *Message) SeverityName() string {
+	if s := m.Severity(); s >= 0 {
+		return severityNames[s]
+	}
+	return ""
+}
+
+// errNoHeader is returned for lines without a syslog header.
+var errNoHeader = errors.New("no syslog header")
+
+// Parse parses a syslog line. Lines starting with a PRI and a version are
+// RFC 5424 messages, others RFC 3164 ones, with or without a PRI, and with
+// either a traditional "Jan  2 15:04:05" timestamp, which is taken to be in
+// local time in the past year, or an RFC 3339 one.
+func Parse(line string) (*Message, error) {
+	m := &Message{Priority: -1}
+	rest := line
+	if strings.HasPrefix(rest, "<") {
+		end := strings.IndexByte(rest, '>')
+		if end < 2 || end > 4 {
+			return nil, fmt.Errorf("bad syslog PRI: %s", line)
+		}
+		priority, err := strconv.Atoi(rest[1:end])
+		if err != nil || priority > 191 {
+			return nil, fmt.Errorf("bad syslog PRI: %s", line)
+		}
+		m.Priority = priority
+		rest = rest[end+1:]
+		if i := strings.IndexByte(rest, ' '); i > 0 {
+			if version, err := strconv.Atoi(rest[:i]); err == nil {
+				m.Version = version
+				if err := parse5424(m, rest[i+1:]); err != nil {
+					return nil, fmt.Errorf("%v: %s", err, line)
+				}
+				return m, nil
+			}
+		}
+	}
+	if err := parse3164(m, rest); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, line)
+	}
+	return m, nil
+}
+
+// parse3164 parses the part of an RFC 3164 message after the PRI:
+//
+//	Jan  2 15:04:05 host app[123]: message
+func parse3164(m *Message, rest string) error {
+	var timestamp string
+	if len(rest) >= 16 && rest[3] == ' ' && rest[6] == ' ' && rest[15] == ' ' {
+		timestamp, rest = rest[:15], rest[16:]
+		t, err := time.ParseInLocation(time.Stamp, timestamp, time.Local)
+		if err != nil {
+			return fmt.Errorf("bad syslog timestamp %q", timestamp)
+		}
+		// the year is left out, the timestamp is the latest one not more
+		// than a day ahead of now
+		current := now()
+		t = t.AddDate(current.Year(), 0, 0)
+		if t.After(current.Add(24 * time.Hour)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		m.Timestamp = t
+	} else {
+		var err error
+		if timestamp, rest, err = field(rest); err != nil {
+			return errNoHeader
+		}
+		if m.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return errNoHeader
+		}
+	}
+
+	var err error
+	if m.Hostname, rest, err = field(rest); err != nil {
+		return errNoHeader
+	}
+
+	// the tag is the app name and the pid in brackets, ending with a colon
+	tagEnd := strings.IndexAny(rest, ":[ ")
+	if tagEnd <= 0 || rest[tagEnd] == ' ' {
+		m.Message = rest
+		return nil
+	}
+	appName, after := rest[:tagEnd], rest[tagEnd:]
+	if strings.HasPrefix(after, "[") {
+		end := strings.Index(after, "]")
+		if end < 0 {
+			m.Message = rest
+			return nil
+		}
+		m.ProcID, after = after[1:end], after[end+1:]
+	}
+	if !strings.HasPrefix(after, ":") {
+		m.ProcID = ""
+		m.Message = rest
+		return nil
+	}
+	m.AppName = appName
+	m.Message = strings.TrimPrefix(after[1:], " ")
+	return nil
+}
+
+// parse5424 parses the part of an RFC 5424 message after the version:
+//
+//	2016-01-02T15:04:05.123Z host app 123 ID47 [exampleSDID@32473 iut="3"] message
+func parse5424(m *Message, rest string) error {
+	var timestamp string
+	var err error
+	if timestamp, rest, err = field(rest); err != nil {
+		return err
+	}
+	if timestamp != "-" {
+		if m.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return fmt.Errorf("bad syslog timestamp %q", timestamp)
+		}
+	}
+	for _, f := range []*string{&m.Hostname, &m.AppName, &m.ProcID, &m.MsgID} {
+		if *f, rest, err = field(rest); err != nil {
+			return err
+		}
+		if *f == "-" {
+			*f = ""
+		}
+	}
+
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else if rest, err = parseStructuredData(m, rest); err != nil {
+		return err
+	}
+	if rest != "" && rest[0] != ' ' {
+		return errors.New("bad syslog structured data")
+	}
+	// the message may start with a byte order mark to show it is UTF-8
+	m.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	return nil
+}
+
+// parseStructuredData parses the SD-ELEMENTs at the start of rest and returns
+// what follows them.
+//
+//	[exampleSDID@32473 iut="3" eventSource="Application"][examplePriority@32473 class="high"]
+func parseStructuredData(m *Message, rest string) (string, error) {
+	errBad := errors.New("bad syslog structured data")
+	m.StructuredData = make(map[string]map[string]string)
+	for strings.HasPrefix(rest, "[") {
+		end := strings.IndexAny(rest, " ]")
+		if end < 2 {
+			return "", errBad
+		}
+		params := make(map[string]string)
+		m.StructuredData[rest[1:end]] = params
+		rest = rest[end:]
+		for strings.HasPrefix(rest, " ") {
+			eq := strings.Index(rest, `="`)
+			if eq < 2 {
+				return "", errBad
+			}
+			name := rest[1:eq]
+			var value strings.Builder
+			i := eq + 2
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				// ", \ and ] are escaped with a backslash
+				if rest[i] == '\\' && i+1 < len(rest) && strings.IndexByte(`"\]`, rest[i+1]) >= 0 {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			if i == len(rest) {
+				return "", errBad
+			}
+			params[name] = value.String()
+			rest = rest[i+1:]
+		}
+		if !strings.HasPrefix(rest, "]") {
+			return "", errBad
+		}
+		rest = rest[1:]
+	}
+	if len(m.StructuredData) == 0 {
+		return "", errBad
+	}
+	return rest, nil
+}
+
+// field returns the text up to the next space and the text after it.
+func field(s string) (string, string, error) {
+	i := strings.IndexByte(s, ' ')
+	if i <= 0 {
+		return "", "", errors.New("truncated syslog header")
+	}
+	return s[:i], s[i+1:], nil
+}
@@ -0,0 +1,80 @@
+// Package syslog implements a logtailer profile that parses syslog messages,
+// in the RFC 3164 (BSD) or the RFC 5424 format, into JSON. Its Parse function
+// parses the syslog header for profiles that go on to parse the message body
+// of particular programs.
+package syslog
+
+import (
+	"encoding/json"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+)
+
+func init() {
+	profiles.Register("syslog", New)
+}
+
+// New creates a SyslogProfile. The app_name option keeps only the messages
+// of that app, like sshd or CRON, and drops the others.
+func New(options profiles.Options) (profiles.Profile, error) {
+	return &SyslogProfile{AppName: options.String("app_name", "")}, nil
+}
+
+// SyslogProfile is a logtailer profile that parses syslog messages.
+type SyslogProfile struct {
+	profiles.StdoutOutput
+
+	// AppName, if set, is the only app whose messages are kept.
+	AppName string
+}
+
+// Name returns the name of the profile and must be unique amongst registered.
+// profiles
+func (p *SyslogProfile) Name() string {
+	return "syslog"
+}
+
+// Init does nothing.
+func (p *SyslogProfile) Init() error {
+	return nil
+}
+
+// ProcessRecord is invoked for every input log line. It returns the message
+// as JSON, nothing if it is from an app other than AppName, or an error if
+// the line has no syslog header.
+func (p *SyslogProfile) ProcessRecord(line string) (interface{}, error) {
+	m, err := Parse(line)
+	if err != nil {
+		return nil, err
+	}
+	if p.AppName != "" && m.AppName != p.AppName {
+		return nil, nil
+	}
+
+	fields := map[string]interface{}{"message": m.Message}
+	if m.Priority >= 0 {
+		fields["priority"] = m.Priority
+		fields["facility"] = m.FacilityName()
+		fields["severity"] = m.SeverityName()
+	}
+	if m.Version > 0 {
+		fields["version"] = m.Version
+	}
+	if !m.Timestamp.IsZero() {
+		fields["time"] = m.Timestamp
+	}
+	for name, value := range map[string]string{
+		"hostname": m.Hostname,
+		"app_name": m.AppName,
+		"procid":   m.ProcID,
+		"msgid":    m.MsgID,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	if len(m.StructuredData) > 0 {
+		fields["structured_data"] = m.StructuredData
+	}
+	return json.Marshal(fields)
+}
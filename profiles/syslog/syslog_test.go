@@ -0,0 +1,109 @@
+package syslog
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ParsePlatform/logtailer/profiles"
+	"github.com/facebookgo/ensure"
+)
+
+func TestParse3164(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2016, 1, 2, 0, 0, 0, 0, time.Local) }
+
+	m, err := Parse("<38>Jan  1 15:04:05 web1 sshd[1234]: Accepted publickey for deploy from 10.0.0.1 port 22 ssh2")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, m, &Message{
+		Priority:  38,
+		Timestamp: time.Date(2016, 1, 1, 15, 4, 5, 0, time.Local),
+		Hostname:  "web1",
+		AppName:   "sshd",
+		ProcID:    "1234",
+		Message:   "Accepted publickey for deploy from 10.0.0.1 port 22 ssh2",
+	})
+	ensure.DeepEqual(t, m.FacilityName(), "auth")
+	ensure.DeepEqual(t, m.SeverityName(), "info")
+
+	// log files have no PRI, and December is from the year before
+	m, err = Parse("Dec 31 23:59:59 web1 CRON: (root) CMD (run-parts /etc/cron.hourly)")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, m.Priority, -1)
+	ensure.DeepEqual(t, m.Facility(), -1)
+	ensure.DeepEqual(t, m.Timestamp, time.Date(2015, 12, 31, 23, 59, 59, 0, time.Local))
+	ensure.DeepEqual(t, m.AppName, "CRON")
+	ensure.DeepEqual(t, m.ProcID, "")
+	ensure.DeepEqual(t, m.Message, "(root) CMD (run-parts /etc/cron.hourly)")
+
+	// high precision timestamps, and a message without a tag
+	m, err = Parse("2016-01-02T15:04:05.123456+01:00 web1 kernel panic - not syncing")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, m.Timestamp.UTC(), time.Date(2016, 1, 2, 14, 4, 5, 123456000, time.UTC))
+	ensure.DeepEqual(t, m.AppName, "")
+	ensure.DeepEqual(t, m.Message, "kernel panic - not syncing")
+}
+
+func TestParse5424(t *testing.T) {
+	m, err := Parse(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication\]"][examplePriority@32473 class="high"] ` + "\ufeff" + `An application event log entry...`)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, m, &Message{
+		Priority:  165,
+		Version:   1,
+		Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+		Hostname:  "mymachine.example.com",
+		AppName:   "evntslog",
+		MsgID:     "ID47",
+		StructuredData: map[string]map[string]string{
+			"exampleSDID@32473":     {"iut": "3", "eventSource": `App"lication]`},
+			"examplePriority@32473": {"class": "high"},
+		},
+		Message: "An application event log entry...",
+	})
+	ensure.DeepEqual(t, m.FacilityName(), "local4")
+	ensure.DeepEqual(t, m.SeverityName(), "notice")
+
+	m, err = Parse("<34>1 - - su - - -")
+	ensure.Nil(t, err)
+	ensure.True(t, m.Timestamp.IsZero())
+	ensure.DeepEqual(t, m.AppName, "su")
+	ensure.DeepEqual(t, m.Message, "")
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"not syslog at all",
+		"<999>Jan  1 15:04:05 web1 sshd[1]: x",
+		"<34>1 2003-10-11T22:14:15Z host app - ID [unterminated",
+		"<34>1 yesterday host app - - - x",
+	} {
+		_, err := Parse(line)
+		ensure.NotNil(t, err, line)
+	}
+}
+
+func TestProcessRecord(t *testing.T) {
+	p, err := New(profiles.Options{"app_name": "sshd"})
+	ensure.Nil(t, err)
+	ensure.Nil(t, p.Init())
+
+	out, err := p.ProcessRecord("<38>2016-01-02T15:04:05Z web1 sshd[1234]: Connection closed by 10.0.0.1")
+	ensure.Nil(t, err)
+	var fields map[string]interface{}
+	ensure.Nil(t, json.Unmarshal(out.([]byte), &fields))
+	ensure.DeepEqual(t, fields, map[string]interface{}{
+		"priority": float64(38),
+		"facility": "auth",
+		"severity": "info",
+		"time":     "2016-01-02T15:04:05Z",
+		"hostname": "web1",
+		"app_name": "sshd",
+		"procid":   "1234",
+		"message":  "Connection closed by 10.0.0.1",
+	})
+
+	out, err = p.ProcessRecord("<38>2016-01-02T15:04:05Z web1 CRON[1]: other app")
+	ensure.Nil(t, err)
+	ensure.True(t, out == nil)
+}
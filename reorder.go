@@ -0,0 +1,47 @@
+package logtailer
+
+import "sort"
+
+// reorderWindow is how many records per worker may be read ahead of the
+// oldest one still being processed in ordered mode.
+const reorderWindow = 64
+
+// A reorderBuffer holds records that finished processing before records read
+// earlier, and releases them once everything before them has finished.
+type reorderBuffer struct {
+	// next is the sequence number of the next record to release.
+	next    uint64
+	pending map[uint64]*record
+}
+
+func newReorderBuffer() *reorderBuffer {
+	return &reorderBuffer{pending: make(map[uint64]*record)}
+}
+
+// add takes a processed record and returns the records that can be released,
+// in the order they were read.
+func (b *reorderBuffer) add(r *record) []*record {
+	b.pending[r.seq] = r
+	var ready []*record
+	for {
+		r, ok := b.pending[b.next]
+		if !ok {
+			return ready
+		}
+		delete(b.pending, b.next)
+		ready = append(ready, r)
+		b.next++
+	}
+}
+
+// flush returns the records still held, in the order they were read. They are
+// left behind by records that were never processed because the tailer stopped.
+func (b *reorderBuffer) flush() []*record {
+	var rest []*record
+	for _, r := range b.pending {
+		rest = append(rest, r)
+	}
+	sort.Slice(rest, func(i, j int) bool { return rest[i].seq < rest[j].seq })
+	b.pending = make(map[uint64]*record)
+	return rest
+}
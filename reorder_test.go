@@ -0,0 +1,80 @@
+package logtailer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+)
+
+func TestReorderBuffer(t *testing.T) {
+	b := newReorderBuffer()
+	ensure.DeepEqual(t, len(b.add(&record{seq: 1})), 0)
+	ensure.DeepEqual(t, len(b.add(&record{seq: 3})), 0)
+	ready := b.add(&record{seq: 0})
+	ensure.DeepEqual(t, len(ready), 2)
+	ensure.DeepEqual(t, ready[0].seq, uint64(0))
+	ensure.DeepEqual(t, ready[1].seq, uint64(1))
+	rest := b.flush()
+	ensure.DeepEqual(t, len(rest), 1)
+	ensure.DeepEqual(t, rest[0].seq, uint64(3))
+}
+
+// slowProfile takes a random time over each record and keeps its output in
+// the order HandleOutput receives it. Records starting with "bad" fail.
+type slowProfile struct {
+	output []string
+}
+
+func (p *slowProfile) Name() string { return "slow" }
+func (p *slowProfile) Init() error  { return nil }
+
+func (p *slowProfile) ProcessRecord(record string) (interface{}, error) {
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	if strings.HasPrefix(record, "bad") {
+		return nil, errors.New("bad record")
+	}
+	return record, nil
+}
+
+func (p *slowProfile) HandleOutput(records <-chan interface{}, dryRun bool) <-chan error {
+	errChan := make(chan error)
+	go func() {
+		defer close(errChan)
+		for r := range records {
+			p.output = append(p.output, r.(string))
+		}
+	}()
+	return errChan
+}
+
+func TestOrderedOutput(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	var lines, expected []string
+	for i := 0; i < 2000; i++ {
+		if i%100 == 50 {
+			lines = append(lines, fmt.Sprint("bad", i))
+			continue
+		}
+		lines = append(lines, fmt.Sprint(i))
+		expected = append(expected, fmt.Sprint(i))
+	}
+	appendLines(t, logFile, lines...)
+
+	p := &slowProfile{}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	lt.Ordered = true
+	stats, _ := lt.Run(8)
+	ensure.DeepEqual(t, stats.ParseErrors, 20)
+	ensure.DeepEqual(t, p.output, expected)
+}
@@ -0,0 +1,79 @@
+package logtailer
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// rotatedSuffixRe matches what log rotation appends to a file name, such as
+// .1, .2.gz or -20160102.zst.
+var rotatedSuffixRe = regexp.MustCompile(`^[.-]([0-9][0-9-]*)(\.gz|\.zst)?$`)
+
+// rotatedSiblings returns the rotated copies of the log file at path, oldest
+// first.
+func rotatedSiblings(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	type sibling struct {
+		path  string
+		info  os.FileInfo
+		index int
+	}
+	var siblings []sibling
+	for _, match := range matches {
+		m := rotatedSuffixRe.FindStringSubmatch(strings.TrimPrefix(match, path))
+		if m == nil {
+			continue
+		}
+		fi, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		// numbered rotations count up with age, dated ones down
+		index, _ := strconv.Atoi(m[1])
+		if strings.Contains(m[1], "-") || len(m[1]) >= 8 {
+			index = -index
+		}
+		siblings = append(siblings, sibling{match, fi, index})
+	}
+
+	sort.Slice(siblings, func(i, j int) bool {
+		ti, tj := siblings[i].info.ModTime(), siblings[j].info.ModTime()
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return siblings[i].index > siblings[j].index
+	})
+	paths := make([]string, len(siblings))
+	for i, s := range siblings {
+		paths[i] = s.path
+	}
+	return paths, nil
+}
+
+// newDecoder returns a decompressing reader for f if the name at path says
+// it is compressed, or nil if it is not.
+func newDecoder(path string, f io.Reader) (io.ReadCloser, error) {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return gzip.NewReader(f)
+	case ".zst":
+		d, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, nil
+}
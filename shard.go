@@ -0,0 +1,25 @@
+package logtailer
+
+import "hash/fnv"
+
+// Sharder is implemented by profiles that keep state across related records.
+// Shard returns a key for the record, and records with the same key are always
+// processed by the same worker, in the order they were read.
+type Sharder interface {
+	Shard(record string) string
+}
+
+// dispatch hands each record to the worker its shard key maps to, and closes
+// the workers' channels once records is closed.
+func dispatch(sharder Sharder, records <-chan *record, shards []chan *record) {
+	defer func() {
+		for _, shard := range shards {
+			close(shard)
+		}
+	}()
+	for r := range records {
+		h := fnv.New32a()
+		h.Write([]byte(sharder.Shard(r.Text)))
+		shards[h.Sum32()%uint32(len(shards))] <- r
+	}
+}
@@ -0,0 +1,52 @@
+package logtailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+)
+
+// shardProfile shards records by the text before the first colon.
+type shardProfile struct {
+	slowProfile
+}
+
+func (p *shardProfile) Shard(record string) string {
+	return strings.SplitN(record, ":", 2)[0]
+}
+
+func TestShardedOrder(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, fmt.Sprintf("%d:%d", i%7, i))
+	}
+	appendLines(t, logFile, lines...)
+
+	p := &shardProfile{}
+	lt := NewLogtailerFiles(p, []string{logFile}, dir, log.New(ioutil.Discard, "", 0))
+	_, err := lt.Run(8)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(p.output), len(lines))
+
+	// records with the same key keep their order
+	last := make(map[string]int)
+	for _, out := range p.output {
+		var key string
+		var i int
+		_, err := fmt.Sscanf(strings.Replace(out, ":", " ", 1), "%s %d", &key, &i)
+		ensure.Nil(t, err)
+		if prev, ok := last[key]; ok && prev > i {
+			t.Fatalf("%s came after %d", out, prev)
+		}
+		last[key] = i
+	}
+}
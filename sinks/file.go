@@ -0,0 +1,56 @@
+package sinks
+
+import (
+	"bufio"
+	"os"
+)
+
+// File appends each record on a line of its own to a file.
+type File struct {
+	Path string
+
+	f *os.File
+	w *bufio.Writer
+}
+
+// NewFile returns a sink appending to the file at path.
+func NewFile(path string) *File {
+	return &File{Path: path}
+}
+
+// Open opens the file for appending, creating it if needed.
+func (s *File) Open() error {
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	return nil
+}
+
+// Write buffers the records.
+func (s *File) Write(records [][]byte) error {
+	return writeLines(s.w, records)
+}
+
+// Flush writes the buffered records to the file and syncs it to disk.
+func (s *File) Flush() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Close flushes and closes the file.
+func (s *File) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	return err
+}
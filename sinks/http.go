@@ -0,0 +1,208 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP posts records to a URL in batches, one record per line, retrying
+// failed requests with exponential backoff.
+type HTTP struct {
+	URL    string
+	Client *http.Client
+	// BatchCount and BatchBytes limit how many records, and how many bytes of
+	// them, are sent in one request. A batch is sent as soon as it is full.
+	BatchCount int
+	BatchBytes int
+	// Interval is how often a batch is sent even if it is not full.
+	Interval time.Duration
+	// Gzip compresses request bodies.
+	Gzip bool
+	// Header is added to every request.
+	Header http.Header
+	// TokenFile holds a bearer token for the Authorization header. It is read
+	// for every request so the token can be rotated.
+	TokenFile string
+	// Retries is how many times a request that failed with a 5xx status, or
+	// without a response, is retried. The first retry waits Backoff, and each
+	// one after that twice as long as the one before, up to MaxBackoff.
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	body  bytes.Buffer
+	count int
+	// err is an error sending a full batch, reported by the next Flush.
+	err error
+}
+
+// NewHTTP returns a sink posting to url.
+func NewHTTP(url string) *HTTP {
+	return &HTTP{
+		URL:        url,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+		BatchCount: 500,
+		BatchBytes: 1024 * 1024,
+		Interval:   time.Second,
+		Header:     make(http.Header),
+		Retries:    5,
+		Backoff:    100 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+	}
+}
+
+// configure sets the fields of the sink from options:
+//
+//	batch_count, batch_bytes, interval, gzip, token_file, retries, backoff,
+//	max_backoff, timeout, and header.NAME for each header to send
+func (s *HTTP) configure(options map[string]string) error {
+	for key, value := range options {
+		var err error
+		switch key {
+		case "batch_count":
+			s.BatchCount, err = strconv.Atoi(value)
+		case "batch_bytes":
+			s.BatchBytes, err = strconv.Atoi(value)
+		case "interval":
+			s.Interval, err = time.ParseDuration(value)
+		case "gzip":
+			s.Gzip, err = strconv.ParseBool(value)
+		case "token_file":
+			s.TokenFile = value
+		case "retries":
+			s.Retries, err = strconv.Atoi(value)
+		case "backoff":
+			s.Backoff, err = time.ParseDuration(value)
+		case "max_backoff":
+			s.MaxBackoff, err = time.ParseDuration(value)
+		case "timeout":
+			s.Client.Timeout, err = time.ParseDuration(value)
+		default:
+			if !strings.HasPrefix(key, "header.") {
+				return fmt.Errorf("unknown http sink option %q", key)
+			}
+			s.Header.Add(strings.TrimPrefix(key, "header."), value)
+		}
+		if err != nil {
+			return fmt.Errorf("bad http sink option %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// Open does nothing, connections are made as needed.
+func (s *HTTP) Open() error {
+	return nil
+}
+
+// Write adds the records to the batch, sending it whenever it fills up.
+func (s *HTTP) Write(records [][]byte) error {
+	for _, record := range records {
+		if s.count > 0 && (s.count >= s.BatchCount || s.body.Len()+len(record)+1 > s.BatchBytes) {
+			if err := s.send(); err != nil && s.err == nil {
+				s.err = err
+			}
+		}
+		s.body.Write(record)
+		s.body.WriteByte('\n')
+		s.count++
+	}
+	return nil
+}
+
+// Flush sends the batch. It fails if this or any batch sent since the last
+// flush could not be delivered.
+func (s *HTTP) Flush() error {
+	err := s.err
+	s.err = nil
+	if s.count > 0 {
+		if sendErr := s.send(); err == nil {
+			err = sendErr
+		}
+	}
+	return err
+}
+
+// Close flushes the sink.
+func (s *HTTP) Close() error {
+	return s.Flush()
+}
+
+// FlushInterval makes the sink a Batcher.
+func (s *HTTP) FlushInterval() time.Duration {
+	return s.Interval
+}
+
+// send posts the batch, retrying as configured. The batch is dropped either
+// way.
+func (s *HTTP) send() error {
+	defer func() {
+		s.body.Reset()
+		s.count = 0
+	}()
+
+	body := s.body.Bytes()
+	if s.Gzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(body)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil || !retry || attempt >= s.Retries {
+			return err
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// post makes a single request, and reports whether it is worth retrying if it
+// fails.
+func (s *HTTP) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range s.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.TokenFile != "" {
+		token, err := ioutil.ReadFile(s.TokenFile)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		err := fmt.Errorf("error posting to %s: %s", s.URL, resp.Status)
+		return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+	}
+	return false, nil
+}
@@ -0,0 +1,175 @@
+package sinks
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+)
+
+// collector is an HTTP server that keeps the bodies it receives, failing the
+// first failures requests with a 503.
+type collector struct {
+	sync.Mutex
+	failures int
+	requests int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	c.requests++
+	if c.failures > 0 {
+		c.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+	buf, _ := ioutil.ReadAll(body)
+	c.bodies = append(c.bodies, string(buf))
+	c.headers = append(c.headers, r.Header)
+}
+
+func newTestHTTP(url string) *HTTP {
+	s := NewHTTP(url)
+	s.Backoff = time.Millisecond
+	s.Interval = 10 * time.Millisecond
+	return s
+}
+
+func TestHTTPBatches(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	s := newTestHTTP(server.URL)
+	s.BatchCount = 2
+	acked, errs := output(s, "1", "2", "3", "4", "5")
+	ensure.DeepEqual(t, len(errs), 0)
+	ensure.DeepEqual(t, acked, 5)
+	ensure.DeepEqual(t, strings.Join(c.bodies, ""), "1\n2\n3\n4\n5\n")
+	for _, body := range c.bodies {
+		ensure.True(t, strings.Count(body, "\n") <= 2)
+	}
+}
+
+func TestHTTPBatchBytes(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	s := newTestHTTP(server.URL)
+	s.BatchBytes = 8
+	ensure.Nil(t, s.Write([][]byte{[]byte("aaa"), []byte("bbb"), []byte("ccc")}))
+	ensure.Nil(t, s.Flush())
+	ensure.DeepEqual(t, c.bodies, []string{"aaa\nbbb\n", "ccc\n"})
+}
+
+func TestHTTPRetries(t *testing.T) {
+	c := &collector{failures: 2}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	s := newTestHTTP(server.URL)
+	ensure.Nil(t, s.Write([][]byte{[]byte("one")}))
+	ensure.Nil(t, s.Flush())
+	ensure.DeepEqual(t, c.requests, 3)
+	ensure.DeepEqual(t, c.bodies, []string{"one\n"})
+}
+
+func TestHTTPGivesUp(t *testing.T) {
+	c := &collector{failures: 10}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	s := newTestHTTP(server.URL)
+	s.Retries = 3
+	acked, errs := output(s, "one", "two")
+	ensure.DeepEqual(t, acked, 0)
+	ensure.DeepEqual(t, len(errs), 1)
+	ensure.DeepEqual(t, c.requests, 4)
+}
+
+func TestHTTPNoRetryOnClientError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	s := newTestHTTP(server.URL)
+	ensure.Nil(t, s.Write([][]byte{[]byte("one")}))
+	ensure.NotNil(t, s.Flush())
+	ensure.DeepEqual(t, requests, 1)
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	c := &collector{}
+	slow := true
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		wait := slow
+		slow = false
+		mu.Unlock()
+		if wait {
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+		c.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	s := newTestHTTP(server.URL)
+	s.Client.Timeout = 50 * time.Millisecond
+	ensure.Nil(t, s.Write([][]byte{[]byte("one")}))
+	ensure.Nil(t, s.Flush())
+	c.Lock()
+	defer c.Unlock()
+	ensure.DeepEqual(t, c.bodies, []string{"one\n"})
+}
+
+func TestHTTPGzipHeadersAndToken(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "sinks")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	ensure.Nil(t, ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600))
+
+	s, err := New(server.URL, map[string]string{
+		"gzip":          "true",
+		"header.X-Tier": "logs",
+		"token_file":    tokenFile,
+	})
+	ensure.Nil(t, err)
+	ensure.Nil(t, s.Write([][]byte{[]byte(`{"a":1}`)}))
+	ensure.Nil(t, s.Flush())
+	ensure.DeepEqual(t, c.bodies, []string{"{\"a\":1}\n"})
+	ensure.DeepEqual(t, c.headers[0].Get("X-Tier"), "logs")
+	ensure.DeepEqual(t, c.headers[0].Get("Authorization"), "Bearer secret")
+	ensure.DeepEqual(t, c.headers[0].Get("Content-Type"), "application/x-ndjson")
+}
@@ -0,0 +1,223 @@
+// Package sinks delivers the output of logtailer profiles, so that where
+// records go can be chosen independently of how they are parsed.
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Sink delivers records somewhere.
+type Sink interface {
+	// Open prepares the sink for writing.
+	Open() error
+	// Write adds a batch of records to the sink. The sink may deliver them
+	// straight away or hold on to them until Flush.
+	Write(records [][]byte) error
+	// Flush delivers every record written so far. Once it returns nil those
+	// records count as acknowledged.
+	Flush() error
+	// Close flushes the sink and releases its resources.
+	Close() error
+}
+
+// A Batcher is a Sink that does better with records piling up between
+// flushes, such as one making a request per flush. Output flushes it every
+// FlushInterval rather than whenever it runs out of records.
+type Batcher interface {
+	Sink
+	FlushInterval() time.Duration
+}
+
+// maxBatch is the largest number of records Output hands to Write at once.
+const maxBatch = 1000
+
+// New returns the sink described by spec, which is one of:
+//
+//	stdout
+//	file:/path/to/file
+//	unix:/path/to/socket
+//	http://host/path or https://host/path
+//
+// Only the HTTP sink takes options, see HTTP.configure.
+func New(spec string, options map[string]string) (Sink, error) {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		s := NewHTTP(spec)
+		return s, s.configure(options)
+	}
+	if len(options) > 0 {
+		return nil, fmt.Errorf("sink %q takes no options", spec)
+	}
+	switch {
+	case spec == "stdout" || spec == "-":
+		return NewStdout(), nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFile(strings.TrimPrefix(spec, "file:")), nil
+	case strings.HasPrefix(spec, "unix:"):
+		return NewUnix(strings.TrimPrefix(spec, "unix:")), nil
+	}
+	return nil, fmt.Errorf("unknown sink %q", spec)
+}
+
+// ParseOptions parses sink options given as comma separated key=value pairs.
+func ParseOptions(s string) (map[string]string, error) {
+	options := make(map[string]string)
+	if s == "" {
+		return options, nil
+	}
+	for _, option := range strings.Split(s, ",") {
+		kv := strings.SplitN(option, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad sink option %q, expected key=value", option)
+		}
+		options[kv[0]] = kv[1]
+	}
+	return options, nil
+}
+
+// Output writes records to sink as they arrive and calls ack(n) once the next
+// n records have been flushed, in the order received. It can serve as the
+// HandleOutputAck of a profile.
+//
+// Records may be []byte, strings or anything that can be marshaled to JSON.
+// Nil records are skipped, and so are records that cannot be marshaled, which
+// are reported as an *EncodeError and acknowledged all the same, as retrying
+// will not help. They do not end the run. Once a write or flush fails the records after it
+// are dropped without being written or acknowledged, and the caller should end
+// the run, so that the failed ones and all those after them are read again by
+// the next one.
+//
+// The sink is neither opened nor closed.
+func Output(sink Sink, records <-chan interface{}, ack func(n int)) <-chan error {
+	errChan := make(chan error)
+	go func() {
+		defer close(errChan)
+
+		var tick <-chan time.Time
+		if b, ok := sink.(Batcher); ok && b.FlushInterval() > 0 {
+			ticker := time.NewTicker(b.FlushInterval())
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		// pending counts the records received since the last flush
+		pending := 0
+		failed := false
+		flush := func() {
+			if pending == 0 || failed {
+				return
+			}
+			if err := sink.Flush(); err != nil {
+				errChan <- err
+				failed = true
+			} else if !failed {
+				ack(pending)
+			}
+			pending = 0
+		}
+
+		var batch [][]byte
+		add := func(record interface{}) {
+			if failed {
+				return
+			}
+			pending++
+			buf, err := encode(record)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			if buf != nil {
+				batch = append(batch, buf)
+			}
+		}
+
+		for {
+			select {
+			case record, ok := <-records:
+				if !ok {
+					flush()
+					return
+				}
+				add(record)
+			case <-tick:
+				flush()
+				continue
+			}
+
+			// take whatever else is ready without waiting for it
+			closed := false
+		drain:
+			for len(batch) < maxBatch {
+				select {
+				case record, ok := <-records:
+					if !ok {
+						closed = true
+						break drain
+					}
+					add(record)
+				default:
+					break drain
+				}
+			}
+
+			if len(batch) > 0 {
+				if err := sink.Write(batch); err != nil {
+					errChan <- err
+					failed = true
+				}
+				batch = nil
+			}
+			if closed {
+				flush()
+				return
+			}
+			if tick == nil {
+				flush()
+			}
+		}
+	}()
+	return errChan
+}
+
+// encode returns the bytes to deliver for record, or nil to skip it.
+func encode(record interface{}) ([]byte, error) {
+	switch r := record.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return r, nil
+	case string:
+		return []byte(r), nil
+	}
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return nil, &EncodeError{Err: err}
+	}
+	return buf, nil
+}
+
+// An EncodeError is reported by Output for a record it cannot encode.
+type EncodeError struct {
+	Err error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("error encoding output record: %v", e.Err)
+}
+
+// writeLines writes each record to w followed by a newline.
+func writeLines(w *bufio.Writer, records [][]byte) error {
+	for _, record := range records {
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
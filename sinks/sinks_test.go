@@ -0,0 +1,228 @@
+package sinks
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+)
+
+// memorySink keeps what is flushed, and fails flushes while failing is set.
+type memorySink struct {
+	written [][]byte
+	flushed []string
+	failing bool
+}
+
+func (s *memorySink) Open() error  { return nil }
+func (s *memorySink) Close() error { return s.Flush() }
+
+func (s *memorySink) Write(records [][]byte) error {
+	s.written = append(s.written, records...)
+	return nil
+}
+
+func (s *memorySink) Flush() error {
+	if s.failing {
+		s.written = nil
+		return errors.New("flush failed")
+	}
+	for _, r := range s.written {
+		s.flushed = append(s.flushed, string(r))
+	}
+	s.written = nil
+	return nil
+}
+
+// output runs records through Output and returns how many were acknowledged
+// and the errors reported.
+func output(sink Sink, records ...interface{}) (int, []error) {
+	in := make(chan interface{})
+	acked := 0
+	errChan := Output(sink, in, func(n int) { acked += n })
+	go func() {
+		for _, r := range records {
+			in <- r
+		}
+		close(in)
+	}()
+	var errs []error
+	for err := range errChan {
+		errs = append(errs, err)
+	}
+	return acked, errs
+}
+
+func TestOutput(t *testing.T) {
+	sink := &memorySink{}
+	acked, errs := output(sink, []byte("one"), "two", nil, map[string]int{"three": 3})
+	ensure.DeepEqual(t, len(errs), 0)
+	ensure.DeepEqual(t, acked, 4)
+	ensure.DeepEqual(t, sink.flushed, []string{"one", "two", `{"three":3}`})
+}
+
+func TestOutputSkipsUnencodable(t *testing.T) {
+	sink := &memorySink{}
+	acked, errs := output(sink, "one", make(chan int), "two")
+	ensure.DeepEqual(t, acked, 3)
+	ensure.DeepEqual(t, len(errs), 1)
+	_, ok := errs[0].(*EncodeError)
+	ensure.True(t, ok)
+	ensure.DeepEqual(t, sink.flushed, []string{"one", "two"})
+}
+
+func TestOutputStopsAckingAfterFailure(t *testing.T) {
+	sink := &memorySink{failing: true}
+	acked, errs := output(sink, "one", "two")
+	ensure.DeepEqual(t, acked, 0)
+	ensure.DeepEqual(t, len(errs), 1)
+
+	// nothing is written after the failure
+	sink = &memorySink{}
+	in := make(chan interface{})
+	errChan := Output(sink, in, func(int) {})
+	sink.failing = true
+	in <- "one"
+	ensure.NotNil(t, <-errChan)
+	sink.failing = false
+	in <- "two"
+	close(in)
+	for range errChan {
+	}
+	ensure.DeepEqual(t, len(sink.flushed), 0)
+	ensure.DeepEqual(t, len(sink.written), 0)
+}
+
+func TestStdoutKeepsLinesWhole(t *testing.T) {
+	r, w, err := os.Pipe()
+	ensure.Nil(t, err)
+	defer r.Close()
+	saved := stdout
+	stdout = bufio.NewWriter(w)
+	defer func() { stdout = saved }()
+
+	// two tailers writing records longer than the buffer at once
+	record := []byte(strings.Repeat("x", 3000))
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := NewStdout()
+			for j := 0; j < 50; j++ {
+				ensure.Nil(t, s.Write([][]byte{record, record}))
+				ensure.Nil(t, s.Flush())
+			}
+		}()
+	}
+	lines := make(chan int)
+	go func() {
+		n := 0
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			ensure.DeepEqual(t, scanner.Text(), string(record))
+			n++
+		}
+		lines <- n
+	}()
+	wg.Wait()
+	w.Close()
+	ensure.DeepEqual(t, <-lines, 200)
+}
+
+func TestNew(t *testing.T) {
+	sink, err := New("stdout", nil)
+	ensure.Nil(t, err)
+	_, ok := sink.(*Stdout)
+	ensure.True(t, ok)
+
+	sink, err = New("file:/tmp/out", nil)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, sink.(*File).Path, "/tmp/out")
+
+	sink, err = New("unix:/run/sock", nil)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, sink.(*Unix).Path, "/run/sock")
+
+	sink, err = New("https://host/input", map[string]string{"gzip": "true", "header.X-Key": "k"})
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, sink.(*HTTP).URL, "https://host/input")
+	ensure.True(t, sink.(*HTTP).Gzip)
+	ensure.DeepEqual(t, sink.(*HTTP).Header.Get("X-Key"), "k")
+
+	_, err = New("stdout", map[string]string{"gzip": "true"})
+	ensure.NotNil(t, err)
+	_, err = New("http://host", map[string]string{"color": "blue"})
+	ensure.NotNil(t, err)
+
+	_, err = New("carrier-pigeon:", nil)
+	ensure.NotNil(t, err)
+}
+
+func TestParseOptions(t *testing.T) {
+	options, err := ParseOptions("gzip=true,header.X-Key=a=b")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, options, map[string]string{"gzip": "true", "header.X-Key": "a=b"})
+	_, err = ParseOptions("gzip")
+	ensure.NotNil(t, err)
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sinks")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out")
+
+	sink := NewFile(path)
+	ensure.Nil(t, sink.Open())
+	acked, errs := output(sink, "one", "two")
+	ensure.Nil(t, sink.Close())
+	ensure.DeepEqual(t, len(errs), 0)
+	ensure.DeepEqual(t, acked, 2)
+	buf, err := ioutil.ReadFile(path)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(buf), "one\ntwo\n")
+}
+
+func TestUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sinks")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", path)
+	ensure.Nil(t, err)
+	defer l.Close()
+
+	lines := make(chan string)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	sink := NewUnix(path)
+	ensure.Nil(t, sink.Open())
+	acked, errs := output(sink, "one", "two")
+	ensure.DeepEqual(t, len(errs), 0)
+	ensure.DeepEqual(t, acked, 2)
+	ensure.Nil(t, sink.Close())
+	var received []string
+	for line := range lines {
+		received = append(received, line)
+	}
+	ensure.DeepEqual(t, received, []string{"one", "two"})
+}
@@ -0,0 +1,416 @@
+package sinks
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrSpoolFull is returned by Spool.Write when the spool has reached MaxSize.
+var ErrSpoolFull = errors.New("spool is full")
+
+// Spool keeps records on disk until another sink has delivered them, so that
+// they survive the sink being unavailable for a while, and logtailer being
+// restarted in the meantime.
+//
+// Records are appended to numbered segment files in Dir, and count as
+// acknowledged once synced to disk. They are handed to the sink in the same
+// order from a separate goroutine, and a cursor file records how far the sink
+// has got. Segments are removed once delivered.
+type Spool struct {
+	Dir  string
+	Sink Sink
+	// SegmentSize is the size a segment grows to before a new one is started,
+	// and MaxSize the size of all the segments beyond which writes fail.
+	SegmentSize int64
+	MaxSize     int64
+	// Retry is how long to wait after the sink fails before trying again,
+	// doubling with each failure up to MaxRetry.
+	Retry    time.Duration
+	MaxRetry time.Duration
+	Logger   *log.Logger
+
+	mu sync.Mutex
+	// segments are the numbers of the segments on disk, oldest first. The last
+	// is being written.
+	segments []int
+	w        *os.File
+	// written and synced are the size of the segment being written, and how
+	// much of it is on disk.
+	written int64
+	synced  int64
+	// size is the size of all the segments.
+	size int64
+
+	wake    chan struct{}
+	closing chan struct{}
+	drained chan struct{}
+
+	// lock is held on the lock file in Dir while the spool is open.
+	lock *os.File
+}
+
+// spoolCursor is how far the sink has got through the spool.
+type spoolCursor struct {
+	Segment int
+	Offset  int64
+}
+
+// NewSpool returns a spool in dir in front of sink.
+func NewSpool(dir string, sink Sink) *Spool {
+	return &Spool{
+		Dir:         dir,
+		Sink:        sink,
+		SegmentSize: 64 * 1024 * 1024,
+		MaxSize:     1024 * 1024 * 1024,
+		Retry:       time.Second,
+		MaxRetry:    time.Minute,
+		Logger:      log.New(os.Stderr, "", log.LstdFlags),
+	}
+}
+
+// Open opens the sink, picks up any records left in the spool by a previous
+// run and starts delivering them. It fails if another process has the spool
+// open.
+func (s *Spool) Open() (err error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(filepath.Join(s.Dir, "lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		return fmt.Errorf("spool %s is in use: %v", s.Dir, err)
+	}
+	defer func() {
+		if err != nil {
+			lock.Close()
+		}
+	}()
+	s.lock = lock
+
+	if err := s.Sink.Open(); err != nil {
+		return err
+	}
+
+	names, err := filepath.Glob(filepath.Join(s.Dir, "*.seg"))
+	if err != nil {
+		return err
+	}
+	cursor, err := s.loadCursor()
+	if err != nil {
+		return err
+	}
+	s.segments, s.size = nil, 0
+	for _, name := range names {
+		n, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(name), ".seg"))
+		if err != nil {
+			continue
+		}
+		// delivered before the last run could remove it
+		if n < cursor.Segment {
+			os.Remove(name)
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, n)
+		s.size += fi.Size()
+	}
+	sort.Ints(s.segments)
+
+	// never append to a segment from a previous run, it may end in a partial
+	// record
+	next := cursor.Segment + 1
+	if len(s.segments) > 0 {
+		next = s.segments[len(s.segments)-1] + 1
+	}
+	if err := s.startSegment(next); err != nil {
+		return err
+	}
+
+	s.wake = make(chan struct{}, 1)
+	s.closing = make(chan struct{})
+	s.drained = make(chan struct{})
+	go s.drain(cursor)
+	return nil
+}
+
+// Write appends the records to the spool.
+func (s *Spool) Write(records [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var size int64
+	for _, record := range records {
+		size += int64(len(record)) + 4
+	}
+	if s.size+size > s.MaxSize {
+		return ErrSpoolFull
+	}
+	if s.written >= s.SegmentSize {
+		if err := s.w.Sync(); err != nil {
+			return err
+		}
+		if err := s.startSegment(s.segments[len(s.segments)-1] + 1); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 0, size)
+	for _, record := range records {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(record)))
+		buf = append(append(buf, n[:]...), record...)
+	}
+	n, err := s.w.Write(buf)
+	s.written += int64(n)
+	s.size += int64(n)
+	return err
+}
+
+// Flush syncs the records written to disk, after which they will be delivered
+// even if logtailer is restarted.
+func (s *Spool) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.synced == s.written {
+		return nil
+	}
+	if err := s.w.Sync(); err != nil {
+		return err
+	}
+	s.synced = s.written
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close flushes the spool, and waits for the records in it to be delivered,
+// unless the sink fails, in which case they are left for the next run.
+func (s *Spool) Close() error {
+	err := s.Flush()
+	close(s.closing)
+	<-s.drained
+
+	s.mu.Lock()
+	if cerr := s.w.Close(); err == nil {
+		err = cerr
+	}
+	s.mu.Unlock()
+
+	if cerr := s.Sink.Close(); err == nil {
+		err = cerr
+	}
+	s.lock.Close()
+	return err
+}
+
+// startSegment starts writing segment n. The caller must hold s.mu.
+func (s *Spool) startSegment(n int) error {
+	f, err := os.OpenFile(s.segmentPath(n), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if s.w != nil {
+		s.w.Close()
+	}
+	s.w = f
+	s.segments = append(s.segments, n)
+	s.written, s.synced = 0, 0
+	return nil
+}
+
+func (s *Spool) segmentPath(n int) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%016d.seg", n))
+}
+
+func (s *Spool) cursorPath() string {
+	return filepath.Join(s.Dir, "cursor")
+}
+
+func (s *Spool) loadCursor() (spoolCursor, error) {
+	var cursor spoolCursor
+	buf, err := ioutil.ReadFile(s.cursorPath())
+	if os.IsNotExist(err) {
+		return cursor, nil
+	}
+	if err != nil {
+		return cursor, err
+	}
+	return cursor, json.Unmarshal(buf, &cursor)
+}
+
+func (s *Spool) saveCursor(cursor spoolCursor) error {
+	buf, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	tmp := s.cursorPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.cursorPath())
+}
+
+// drain hands the spooled records to the sink until the spool is closed,
+// waiting for more to be flushed whenever it catches up, and retrying with
+// backoff when the sink fails.
+func (s *Spool) drain(cursor spoolCursor) {
+	defer close(s.drained)
+	retry := s.Retry
+	for {
+		err := s.deliver(&cursor)
+		if err == nil {
+			retry = s.Retry
+			select {
+			case <-s.wake:
+				continue
+			case <-s.closing:
+				// deliver anything flushed just before closing
+				if err := s.deliver(&cursor); err != nil {
+					s.Logger.Println("error delivering spooled records:", err)
+					s.Logger.Println("leaving records in spool", s.Dir, "for the next run")
+				}
+				return
+			}
+		}
+
+		s.Logger.Println("error delivering spooled records:", err)
+		select {
+		case <-time.After(retry):
+		case <-s.closing:
+			s.Logger.Println("leaving records in spool", s.Dir, "for the next run")
+			return
+		}
+		if retry *= 2; retry > s.MaxRetry {
+			retry = s.MaxRetry
+		}
+	}
+}
+
+// deliver hands the sink everything synced past the cursor, moving the cursor
+// along as the sink flushes successfully.
+func (s *Spool) deliver(cursor *spoolCursor) error {
+	for {
+		s.mu.Lock()
+		if len(s.segments) > 0 && cursor.Segment < s.segments[0] {
+			*cursor = spoolCursor{Segment: s.segments[0]}
+		}
+		current := s.segments[len(s.segments)-1]
+		limit := int64(-1)
+		if cursor.Segment == current {
+			limit = s.synced
+		}
+		s.mu.Unlock()
+
+		if err := s.deliverSegment(cursor, limit); err != nil {
+			return err
+		}
+		if cursor.Segment == current {
+			return nil
+		}
+
+		// the segment is delivered in full
+		path := s.segmentPath(cursor.Segment)
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.segments = s.segments[1:]
+		next := spoolCursor{Segment: s.segments[0]}
+		s.mu.Unlock()
+		if err := s.saveCursor(next); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.size -= fi.Size()
+		s.mu.Unlock()
+		*cursor = next
+	}
+}
+
+// deliverSegment hands the sink the records in the cursor's segment from the
+// cursor up to limit, or to the end of the segment if limit is negative. A
+// partial record at the end of a segment left by a crash is ignored.
+func (s *Spool) deliverSegment(cursor *spoolCursor, limit int64) error {
+	f, err := os.Open(s.segmentPath(cursor.Segment))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(cursor.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	var in io.Reader = f
+	if limit >= 0 {
+		in = io.LimitReader(f, limit-cursor.Offset)
+	}
+	r := bufio.NewReader(in)
+
+	offset := cursor.Offset
+	for {
+		var batch [][]byte
+		end := offset
+		for len(batch) < maxBatch {
+			var n [4]byte
+			if _, err := io.ReadFull(r, n[:]); err != nil {
+				break
+			}
+			record := make([]byte, binary.BigEndian.Uint32(n[:]))
+			if _, err := io.ReadFull(r, record); err != nil {
+				break
+			}
+			batch = append(batch, record)
+			end += int64(len(record)) + 4
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := s.Sink.Write(batch); err != nil {
+			return err
+		}
+		if err := s.Sink.Flush(); err != nil {
+			return err
+		}
+		offset = end
+		*cursor = spoolCursor{Segment: cursor.Segment, Offset: offset}
+		if err := s.saveCursor(*cursor); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package sinks
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+)
+
+func newTestSpool(t *testing.T, dir string, sink Sink) *Spool {
+	s := NewSpool(dir, sink)
+	s.Retry = time.Millisecond
+	s.Logger = log.New(ioutil.Discard, "", 0)
+	ensure.Nil(t, s.Open())
+	return s
+}
+
+func records(texts ...string) [][]byte {
+	var records [][]byte
+	for _, text := range texts {
+		records = append(records, []byte(text))
+	}
+	return records
+}
+
+func segments(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	ensure.Nil(t, err)
+	return names
+}
+
+func TestSpoolDelivers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &memorySink{}
+	s := newTestSpool(t, dir, sink)
+	s.SegmentSize = 10
+	acked, errs := output(s, "one", "two", "three", "four")
+	ensure.DeepEqual(t, len(errs), 0)
+	ensure.DeepEqual(t, acked, 4)
+	ensure.Nil(t, s.Close())
+	ensure.DeepEqual(t, sink.flushed, []string{"one", "two", "three", "four"})
+	ensure.DeepEqual(t, len(segments(t, dir)), 1)
+}
+
+func TestSpoolResumesAfterOutage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the records are acknowledged while the sink is down
+	down := &memorySink{failing: true}
+	s := newTestSpool(t, dir, down)
+	s.SegmentSize = 10
+	acked, errs := output(s, "one", "two", "three")
+	ensure.DeepEqual(t, len(errs), 0)
+	ensure.DeepEqual(t, acked, 3)
+	s.Close()
+	ensure.DeepEqual(t, len(down.flushed), 0)
+
+	// and delivered in order once it is back
+	up := &memorySink{}
+	s = newTestSpool(t, dir, up)
+	ensure.Nil(t, s.Write(records("four")))
+	ensure.Nil(t, s.Close())
+	ensure.DeepEqual(t, up.flushed, []string{"one", "two", "three", "four"})
+
+	// and not again
+	again := &memorySink{}
+	s = newTestSpool(t, dir, again)
+	ensure.Nil(t, s.Close())
+	ensure.DeepEqual(t, len(again.flushed), 0)
+}
+
+func TestSpoolIgnoresPartialRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newTestSpool(t, dir, &memorySink{failing: true})
+	ensure.Nil(t, s.Write(records("one")))
+	s.Close()
+
+	// a crash left half a record behind
+	f, err := os.OpenFile(segments(t, dir)[0], os.O_WRONLY|os.O_APPEND, 0644)
+	ensure.Nil(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 9, 't', 'w'})
+	ensure.Nil(t, err)
+	ensure.Nil(t, f.Close())
+
+	sink := &memorySink{}
+	s = newTestSpool(t, dir, sink)
+	ensure.Nil(t, s.Write(records("three")))
+	ensure.Nil(t, s.Close())
+	ensure.DeepEqual(t, sink.flushed, []string{"one", "three"})
+}
+
+func TestSpoolFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newTestSpool(t, dir, &memorySink{failing: true})
+	s.MaxSize = 10
+	ensure.Nil(t, s.Write(records("one")))
+	ensure.DeepEqual(t, s.Write(records("two")), ErrSpoolFull)
+	s.Close()
+}
+
+func TestSpoolLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	ensure.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newTestSpool(t, dir, &memorySink{})
+	other := NewSpool(dir, &memorySink{})
+	ensure.NotNil(t, other.Open())
+	ensure.Nil(t, s.Close())
+
+	// free once closed
+	ensure.Nil(t, other.Open())
+	ensure.Nil(t, other.Close())
+}
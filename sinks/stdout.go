@@ -0,0 +1,47 @@
+package sinks
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// stdout is the writer shared by every Stdout sink. Records are written to it
+// a whole batch at a time with stdoutMu held, so that the output of tailers
+// running side by side is never interleaved within a line.
+var (
+	stdout   = bufio.NewWriter(os.Stdout)
+	stdoutMu sync.Mutex
+)
+
+// Stdout writes each record on a line of its own to standard output.
+type Stdout struct{}
+
+// NewStdout returns a sink writing to standard output. It needs no Open.
+func NewStdout() *Stdout {
+	return &Stdout{}
+}
+
+// Open does nothing.
+func (s *Stdout) Open() error {
+	return nil
+}
+
+// Write buffers the records.
+func (s *Stdout) Write(records [][]byte) error {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	return writeLines(stdout, records)
+}
+
+// Flush writes the buffered records to standard output.
+func (s *Stdout) Flush() error {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	return stdout.Flush()
+}
+
+// Close flushes the sink.
+func (s *Stdout) Close() error {
+	return s.Flush()
+}
@@ -0,0 +1,89 @@
+package sinks
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+// Unix writes each record on a line of its own to a Unix stream socket,
+// dialing it again if the connection breaks.
+type Unix struct {
+	Path string
+	// Timeout bounds connecting and each write.
+	Timeout time.Duration
+
+	conn net.Conn
+	w    *bufio.Writer
+	// broken is the error that lost records written since the last flush.
+	broken error
+}
+
+// NewUnix returns a sink writing to the socket at path.
+func NewUnix(path string) *Unix {
+	return &Unix{Path: path, Timeout: 10 * time.Second}
+}
+
+// Open connects to the socket.
+func (s *Unix) Open() error {
+	conn, err := net.DialTimeout("unix", s.Path, s.Timeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.w = bufio.NewWriter(conn)
+	return nil
+}
+
+// Write buffers the records, connecting first if the connection was lost.
+func (s *Unix) Write(records [][]byte) error {
+	if s.conn == nil {
+		if err := s.Open(); err != nil {
+			s.broken = err
+			return err
+		}
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+	if err := writeLines(s.w, records); err != nil {
+		s.disconnect(err)
+		return err
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the socket. It fails if any records
+// written since the last flush were lost.
+func (s *Unix) Flush() error {
+	if s.broken != nil {
+		err := s.broken
+		s.broken = nil
+		return err
+	}
+	if s.conn == nil {
+		return nil
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+	if err := s.w.Flush(); err != nil {
+		s.disconnect(nil)
+		return err
+	}
+	return nil
+}
+
+// Close flushes the sink and closes the connection.
+func (s *Unix) Close() error {
+	err := s.Flush()
+	if s.conn != nil {
+		if cerr := s.conn.Close(); err == nil {
+			err = cerr
+		}
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *Unix) disconnect(err error) {
+	s.conn.Close()
+	s.conn = nil
+	s.broken = err
+}
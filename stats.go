@@ -2,6 +2,7 @@ package logtailer
 
 import (
 	"encoding/json"
+	"fmt"
 	"sync"
 )
 
@@ -10,6 +11,10 @@ type Stats struct {
 	Records     int
 	ParseErrors int
 	SendErrors  int
+	// Oversized counts records longer than the maximum record size.
+	Oversized int
+	// Bytes counts the bytes read from the input.
+	Bytes int64
 	sync.Mutex
 }
 
@@ -30,7 +35,28 @@ func (s *Stats) IsHealthy() bool {
 	return true
 }
 
+// An UnhealthyError is returned by Run when the stats of the run indicate it
+// went badly, see Stats.IsHealthy.
+type UnhealthyError struct {
+	Stats *Stats
+}
+
+func (e *UnhealthyError) Error() string {
+	return fmt.Sprintf("stats indicate unhealthy run: %+v", e.Stats)
+}
+
 func (s *Stats) String() string {
 	buf, _ := json.Marshal(s)
 	return string(buf)
 }
+
+// add adds the counts in o, which may still be being updated, to s.
+func (s *Stats) add(o *Stats) {
+	o.Lock()
+	defer o.Unlock()
+	s.Records += o.Records
+	s.ParseErrors += o.ParseErrors
+	s.SendErrors += o.SendErrors
+	s.Oversized += o.Oversized
+	s.Bytes += o.Bytes
+}
@@ -0,0 +1,218 @@
+package logtailer
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoverInterval is how often glob patterns are expanded again in follow
+// mode to pick up newly created log files.
+var discoverInterval = 5 * time.Second
+
+// A tail tracks the consumption of a single log file.
+type tail struct {
+	path      string
+	stateFile string
+	// legacyStateFile is where the checkpoint was kept when a Logtailer could
+	// only consume one file.
+	legacyStateFile string
+
+	// spill receives oversized records under SpillOversize, opened on first
+	// use and closed when the file is done with.
+	spill *os.File
+
+	positionMu sync.Mutex
+	// position is the checkpoint reached in the file, nil until something read
+	// from it is done with.
+	position *checkpoint
+}
+
+// tailInput pairs a tail with the segments it has left to read.
+type tailInput struct {
+	tail     *tail
+	segments []*segment
+}
+
+func (in *tailInput) close() {
+	for _, seg := range in.segments {
+		seg.Close()
+	}
+}
+
+// newTail returns a tail for the log file at path, see track.
+func (lt *Logtailer) newTail(path string) *tail {
+	t := &tail{
+		path:            path,
+		stateFile:       lt.stateFilePath(path),
+		legacyStateFile: filepath.Join(lt.StateDir, fmt.Sprintf("logtailer-%s-%s.state", lt.Profile.Name(), filepath.Base(path))),
+	}
+	return t
+}
+
+// track records that t is being read, to be checkpointed and not discovered
+// again.
+func (lt *Logtailer) track(t *tail) {
+	lt.tailsMu.Lock()
+	lt.tails[t.path] = t
+	lt.tailsMu.Unlock()
+}
+
+// stateFilePath returns the path of the checkpoint for the log file at path.
+// The full path of the log file is part of the name so that files with the
+// same name in different directories are tracked separately.
+func (lt *Logtailer) stateFilePath(path string) string {
+	fileName := fmt.Sprintf("logtailer-%s-%s.state", lt.Profile.Name(), flattenPath(path))
+	return filepath.Join(lt.StateDir, fileName)
+}
+
+// DefaultSpoolDir returns a spool directory in StateDir named, like the
+// checkpoints, after the profile and the log files, so that tailers of the
+// same profile reading different files each have their own.
+func (lt *Logtailer) DefaultSpoolDir() string {
+	var names []string
+	for _, path := range lt.logFiles() {
+		names = append(names, flattenPath(path))
+	}
+	logFileNames := strings.Join(names, ",")
+	// keep within the limit on the length of file names
+	if len(logFileNames) > 200 {
+		logFileNames = fmt.Sprintf("%x", sha1.Sum([]byte(logFileNames)))
+	}
+	fileName := fmt.Sprintf("logtailer-%s-%s.spool", lt.Profile.Name(), logFileNames)
+	return filepath.Join(lt.StateDir, fileName)
+}
+
+// flattenPath turns the absolute path of path into a file name.
+func flattenPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return strings.Replace(strings.TrimPrefix(path, "/"), "/", "_", -1)
+}
+
+// loadCheckpoint reads the checkpoint for the tail, falling back to the state
+// file used before multiple files were supported.
+func (t *tail) loadCheckpoint() (*checkpoint, error) {
+	cp, err := loadCheckpoint(t.stateFile)
+	if cp != nil || err != nil {
+		return cp, err
+	}
+	return loadCheckpoint(t.legacyStateFile)
+}
+
+// setPosition records that everything up to cp has been consumed.
+func (t *tail) setPosition(cp *checkpoint) {
+	t.positionMu.Lock()
+	t.position = cp
+	t.positionMu.Unlock()
+}
+
+// save persists the position reached in the file.
+func (t *tail) save() error {
+	t.positionMu.Lock()
+	defer t.positionMu.Unlock()
+	if t.position == nil {
+		return nil
+	}
+	return t.position.save(t.stateFile)
+}
+
+// isGlob reports whether path contains any glob metacharacters.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// logFiles returns LogFile, if set, followed by LogFiles.
+func (lt *Logtailer) logFiles() []string {
+	if lt.LogFile == "" {
+		return lt.LogFiles
+	}
+	return append([]string{lt.LogFile}, lt.LogFiles...)
+}
+
+// expandLogFiles returns the paths of the log files to consume, with glob
+// patterns replaced by the files they match.
+func (lt *Logtailer) expandLogFiles() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, pattern := range lt.logFiles() {
+		matches := []string{pattern}
+		if isGlob(pattern) {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				lt.Logger.Printf("bad log file pattern %s: %v", pattern, err)
+			}
+		}
+		for _, path := range matches {
+			if isGlob(pattern) && isRotated(path, matches) {
+				continue
+			}
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// isRotated reports whether path is a rotated copy of one of paths, which is
+// read as part of catching up with that file rather than on its own.
+func isRotated(path string, paths []string) bool {
+	for _, other := range paths {
+		if strings.HasPrefix(path, other) && rotatedSuffixRe.MatchString(strings.TrimPrefix(path, other)) {
+			return true
+		}
+	}
+	return false
+}
+
+// discover periodically expands the log file patterns and starts scanning any
+// file that has appeared since, until the tailer is stopped.
+func (lt *Logtailer) discover(records chan<- *record, stats *Stats, scanners *sync.WaitGroup) {
+	hasGlob := false
+	for _, pattern := range lt.logFiles() {
+		hasGlob = hasGlob || isGlob(pattern)
+	}
+	if !hasGlob {
+		return
+	}
+
+	ticker := time.NewTicker(discoverInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-lt.shutdown:
+			return
+		}
+		for _, path := range lt.expandLogFiles() {
+			lt.tailsMu.Lock()
+			_, ok := lt.tails[path]
+			lt.tailsMu.Unlock()
+			if ok {
+				continue
+			}
+
+			lt.Logger.Println("found new log file", path)
+			t := lt.newTail(path)
+			input, err := lt.getInput(t)
+			if err != nil {
+				// try again on the next round
+				lt.Logger.Println("error getting logtail input:", err)
+				continue
+			}
+			lt.track(t)
+			scanners.Add(1)
+			go func() {
+				defer scanners.Done()
+				lt.scan(t, input, records, stats)
+			}()
+		}
+	}
+}